@@ -0,0 +1,56 @@
+package main
+
+import (
+	"math/rand"
+	"time"
+)
+
+const defaultPollInterval = 30 * time.Second
+
+var jitterRand = rand.New(rand.NewSource(time.Now().UnixNano()))
+
+// backoff computes the delay between polls. The delay starts at interval and
+// grows by multiplier after every poll up to maxInterval. jitter randomizes
+// each delay by up to that fraction in either direction so that many waiters
+// started at once don't poll the API in lockstep.
+type backoff struct {
+	interval    time.Duration
+	maxInterval time.Duration
+	multiplier  float64
+	jitter      float64
+
+	current time.Duration
+}
+
+// next returns the delay before the next poll and advances the backoff.
+func (b *backoff) next() time.Duration {
+	if b.current <= 0 {
+		b.current = b.interval
+		if b.current <= 0 {
+			b.current = defaultPollInterval
+		}
+	}
+
+	delay := b.current
+
+	if b.multiplier > 1 {
+		b.current = time.Duration(float64(b.current) * b.multiplier)
+	}
+	if b.maxInterval > 0 && b.current > b.maxInterval {
+		b.current = b.maxInterval
+	}
+	if b.maxInterval > 0 && delay > b.maxInterval {
+		delay = b.maxInterval
+	}
+
+	if b.jitter > 0 {
+		delay = time.Duration(float64(delay) * (1 + b.jitter*(2*jitterRand.Float64()-1)))
+	}
+
+	return delay
+}
+
+// reset starts the backoff over from the base interval.
+func (b *backoff) reset() {
+	b.current = 0
+}
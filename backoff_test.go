@@ -0,0 +1,72 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestBackoffNext(t *testing.T) {
+	testCases := []struct {
+		name    string
+		backoff backoff
+		delays  []time.Duration
+	}{
+		{
+			name:    "default interval",
+			backoff: backoff{},
+			delays:  []time.Duration{defaultPollInterval, defaultPollInterval},
+		}, {
+			name: "constant interval",
+			backoff: backoff{
+				interval:   10 * time.Second,
+				multiplier: 1,
+			},
+			delays: []time.Duration{10 * time.Second, 10 * time.Second},
+		}, {
+			name: "exponential with max",
+			backoff: backoff{
+				interval:    10 * time.Second,
+				maxInterval: 30 * time.Second,
+				multiplier:  2,
+			},
+			delays: []time.Duration{10 * time.Second, 20 * time.Second, 30 * time.Second, 30 * time.Second},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			var delays []time.Duration
+			for range tc.delays {
+				delays = append(delays, tc.backoff.next())
+			}
+			assert.Equal(t, tc.delays, delays)
+		})
+	}
+}
+
+func TestBackoffJitter(t *testing.T) {
+	b := backoff{
+		interval: 10 * time.Second,
+		jitter:   0.5,
+	}
+
+	for i := 0; i < 100; i++ {
+		delay := b.next()
+		assert.True(t, delay >= 5*time.Second && delay <= 15*time.Second, "delay %s out of range", delay)
+	}
+}
+
+func TestBackoffReset(t *testing.T) {
+	b := backoff{
+		interval:   time.Second,
+		multiplier: 2,
+	}
+
+	b.next()
+	b.next()
+	b.reset()
+
+	assert.Equal(t, time.Second, b.next())
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"path"
+	"regexp"
+	"strings"
+
+	"github.com/pkg/errors"
+)
+
+// regexPrefix marks a branch pattern as a regular expression rather than a
+// glob.
+const regexPrefix = "re:"
+
+// branchPattern matches branch names with either a glob or a regular
+// expression.
+type branchPattern struct {
+	glob string
+	re   *regexp.Regexp
+}
+
+func parseBranchPattern(pattern string) (branchPattern, error) {
+	if strings.HasPrefix(pattern, regexPrefix) {
+		re, err := regexp.Compile(strings.TrimPrefix(pattern, regexPrefix))
+		if err != nil {
+			return branchPattern{}, errors.Wrapf(err, "invalid branch pattern %q", pattern)
+		}
+		return branchPattern{re: re}, nil
+	}
+
+	if _, err := path.Match(pattern, ""); err != nil {
+		return branchPattern{}, errors.Wrapf(err, "invalid branch pattern %q", pattern)
+	}
+	return branchPattern{glob: pattern}, nil
+}
+
+func (p branchPattern) match(branch string) bool {
+	if p.re != nil {
+		return p.re.MatchString(branch)
+	}
+	matched, _ := path.Match(p.glob, branch)
+	return matched
+}
+
+// branchGroup is a set of branches whose builds wait on each other.
+type branchGroup []branchPattern
+
+func (g branchGroup) match(branch string) bool {
+	for _, p := range g {
+		if p.match(branch) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseBranchGroups parses one group per spec, each a comma separated list
+// of glob patterns or regular expressions prefixed with "re:".
+func parseBranchGroups(specs []string) ([]branchGroup, error) {
+	var groups []branchGroup
+	for _, spec := range specs {
+		var group branchGroup
+		for _, pattern := range strings.Split(spec, ",") {
+			pattern = strings.TrimSpace(pattern)
+			if pattern == "" {
+				continue
+			}
+			p, err := parseBranchPattern(pattern)
+			if err != nil {
+				return nil, err
+			}
+			group = append(group, p)
+		}
+		if len(group) > 0 {
+			groups = append(groups, group)
+		}
+	}
+	return groups, nil
+}
+
+// sameBranchGroup reports whether builds on other wait on, or are waited on
+// by, builds on branch. Branches outside of every group only match
+// themselves.
+func (m monitor) sameBranchGroup(branch, other string) bool {
+	if branch == other {
+		return true
+	}
+	for _, g := range m.branchGroups {
+		if g.match(branch) {
+			return g.match(other)
+		}
+	}
+	return false
+}
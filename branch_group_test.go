@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseBranchGroups(t *testing.T) {
+	groups, err := parseBranchGroups([]string{"main, hotfix/*", "re:^release/[0-9.]+$", ""})
+	require.NoError(t, err)
+	require.Len(t, groups, 2)
+	assert.Len(t, groups[0], 2)
+	assert.Len(t, groups[1], 1)
+
+	_, err = parseBranchGroups([]string{"re:release/("})
+	require.Error(t, err)
+
+	_, err = parseBranchGroups([]string{"release/["})
+	require.Error(t, err)
+}
+
+func TestSameBranchGroup(t *testing.T) {
+	groups, err := parseBranchGroups([]string{"main,hotfix/*", "re:^release/[0-9.]+$"})
+	require.NoError(t, err)
+
+	monitor := &monitor{
+		branchGroups: groups,
+	}
+
+	testCases := []struct {
+		branch   string
+		other    string
+		expected bool
+	}{
+		{branch: "main", other: "hotfix/login", expected: true},
+		{branch: "hotfix/login", other: "hotfix/signup", expected: true},
+		{branch: "release/1.0", other: "release/1.1", expected: true},
+		{branch: "release/1.0", other: "release/next", expected: false},
+		{branch: "main", other: "release/1.0", expected: false},
+		{branch: "feature", other: "feature", expected: true},
+		{branch: "feature", other: "main", expected: false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.branch+" "+tc.other, func(t *testing.T) {
+			assert.Equal(t, tc.expected, monitor.sameBranchGroup(tc.branch, tc.other))
+		})
+	}
+}
+
+func TestBuildsToWatchBranchGroup(t *testing.T) {
+	groups, err := parseBranchGroups([]string{"main,hotfix/*"})
+	require.NoError(t, err)
+
+	monitor := &monitor{
+		buildGetter: mockBuildList{
+			builds: []codeship.Build{
+				{UUID: "1", Status: "testing", Branch: "main"},
+				{UUID: "2", Status: "testing", Branch: "hotfix/login"},
+				{UUID: "3", Status: "testing", Branch: "feature"},
+			},
+		},
+		branchGroups: groups,
+	}
+
+	builds, err := monitor.buildsToWatch(context.TODO(), "project-id", "main")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"1", "2"}, uuids(builds))
+}
@@ -0,0 +1,472 @@
+package main
+
+import (
+	"flag"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// parseFlags parses the command line. Invalid flags are returned rather than
+// exiting with the flag package's own code, so they exit as a configuration
+// error like every other invalid setting.
+func parseFlags(args []string) error {
+	pflag.CommandLine.Init(args[0], pflag.ContinueOnError)
+	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
+	return pflag.CommandLine.Parse(args[1:])
+}
+
+// defineFlags defines the flags shared by every command.
+func defineFlags() {
+	pflag.Duration("max-wait", 0, "maximum time to wait on previous builds, 0 waits forever")
+	pflag.String("on-timeout", string(timeoutFail), "action when --max-wait is exceeded: fail, proceed or stop")
+	pflag.Duration("poll-interval", defaultPollInterval, "base interval between polls of a running build")
+	pflag.Duration("poll-max-interval", 5*time.Minute, "maximum interval between polls when backing off")
+	pflag.Float64("poll-multiplier", 1, "factor the poll interval grows by while a build keeps running")
+	pflag.Float64("poll-jitter", 0, "fraction between 0 and 1 by which each poll interval is randomized")
+	pflag.Int("max-retries", defaultMaxRetries, "number of times a rate limited or failed API call is retried")
+	pflag.Bool("supersede", false, "stop older running builds on the branch instead of waiting on them")
+	pflag.StringSlice("supersede-protected", []string{"master"}, "branches, or glob patterns, on which --supersede never stops builds")
+	pflag.String("on-newer-build", string(newerIgnore), "action when a newer build for the branch appears while waiting: ignore, stop or exit")
+	pflag.Bool("require-previous-success", false, "fail when a build ahead of ours does not succeed")
+	pflag.Bool("ignore-stopped", false, "do not count stopped builds as failures with --require-previous-success")
+	pflag.String("unknown-status", stateRunning.String(), "state assumed for unknown build statuses: queued, running, succeeded, failed or cancelled")
+	pflag.String("order", string(orderQueued), "how builds are ordered in the queue: queued, allocated or ancestry")
+	pflag.Int("max-concurrent", 1, "number of builds on the branch allowed to run at the same time")
+	pflag.String("lock", "", "name of a lock shared with the builds of --lock-projects")
+	pflag.StringSlice("lock-projects", nil, "UUIDs of the projects sharing --lock")
+	pflag.StringArray("branch-group", nil, "comma separated globs, or regular expressions prefixed with re:, of branches sharing a queue; may be repeated")
+	pflag.StringSlice("steps", nil, "names of the steps of older builds to wait on instead of the whole build (Pro projects)")
+	pflag.String("pipeline", "", "type of the pipeline of older builds to wait on instead of the whole build (Basic projects)")
+	pflag.Bool("dry-run", false, "log builds that would be stopped without stopping them")
+	pflag.String("project", "", "UUID of the project for commands that take one, defaults to CI_PROJECT_ID")
+	pflag.String("build", "", "UUID of the build for wait-for")
+	pflag.String("commit", "", "commit SHA identifying the build for wait-for and gate")
+	pflag.String("branch", "", "branch identifying the build for wait-for and gate, or the queue for status")
+	pflag.Bool("create", false, "trigger a build of --commit with gate when there is none")
+	pflag.String("output", string(outputTable), "output format of status: table or json")
+	pflag.Duration("refresh", 5*time.Second, "interval at which watch refreshes the queue")
+	pflag.String("log-format", string(logText), "format of log events: text or json")
+	pflag.String("log-level", levelInfo.String(), "minimum level of log events: debug, info, warn or error")
+	pflag.String("metrics-addr", "", "address to serve Prometheus metrics on, e.g. :9090")
+	pflag.String("metrics-textfile", "", "path to write Prometheus metrics to on exit, for the node_exporter textfile collector")
+	pflag.String("result-file", "", "path to write a JSON document describing the wait to on exit")
+	pflag.Duration("shutdown-timeout", defaultShutdownTimeout, "time an interrupted run gets to wind down and run its cleanup commands")
+	pflag.StringArray("on-interrupt", nil, "command run with sh when interrupted, may be repeated")
+	pflag.String("config", "", "path to a YAML, TOML or JSON config file with per-branch policies, defaults to "+defaultConfigFile+" if it exists")
+}
+
+// bindEnv binds the configuration keys to their environment variables.
+func bindEnv() error {
+	var err error
+
+	// CODESHIP_USERNAME
+	err = viper.BindEnv("username")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_PASSWORD
+	err = viper.BindEnv("password")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_ORGANIZATION
+	err = viper.BindEnv("organization")
+	if err != nil {
+		return err
+	}
+
+	// CI_PROJECT_ID
+	err = viper.BindEnv("project_id", "CI_PROJECT_ID")
+	if err != nil {
+		return err
+	}
+
+	// CI_BUILD_ID
+	err = viper.BindEnv("build_id", "CI_BUILD_ID")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_MAX_WAIT
+	err = viper.BindEnv("max-wait")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_ON_TIMEOUT
+	err = viper.BindEnv("on-timeout")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_POLL_INTERVAL
+	err = viper.BindEnv("poll-interval")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_POLL_MAX_INTERVAL
+	err = viper.BindEnv("poll-max-interval")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_POLL_MULTIPLIER
+	err = viper.BindEnv("poll-multiplier")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_POLL_JITTER
+	err = viper.BindEnv("poll-jitter")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_MAX_RETRIES
+	err = viper.BindEnv("max-retries")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_SUPERSEDE
+	err = viper.BindEnv("supersede")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_SUPERSEDE_PROTECTED
+	err = viper.BindEnv("supersede-protected")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_ON_NEWER_BUILD
+	err = viper.BindEnv("on-newer-build")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_REQUIRE_PREVIOUS_SUCCESS
+	err = viper.BindEnv("require-previous-success")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_IGNORE_STOPPED
+	err = viper.BindEnv("ignore-stopped")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_UNKNOWN_STATUS
+	err = viper.BindEnv("unknown-status")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_ORDER
+	err = viper.BindEnv("order")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_MAX_CONCURRENT
+	err = viper.BindEnv("max-concurrent")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_LOCK
+	err = viper.BindEnv("lock")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_LOCK_PROJECTS
+	err = viper.BindEnv("lock-projects")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_BRANCH_GROUP
+	err = viper.BindEnv("branch-group")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_STEPS
+	err = viper.BindEnv("steps")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_PIPELINE
+	err = viper.BindEnv("pipeline")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_DRY_RUN
+	err = viper.BindEnv("dry-run")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_PROJECT
+	err = viper.BindEnv("project")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_BUILD
+	err = viper.BindEnv("build")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_COMMIT
+	err = viper.BindEnv("commit")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_BRANCH
+	err = viper.BindEnv("branch")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_CREATE
+	err = viper.BindEnv("create")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_OUTPUT
+	err = viper.BindEnv("output")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_REFRESH
+	err = viper.BindEnv("refresh")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_LOG_FORMAT
+	err = viper.BindEnv("log-format")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_LOG_LEVEL
+	err = viper.BindEnv("log-level")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_METRICS_ADDR
+	err = viper.BindEnv("metrics-addr")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_METRICS_TEXTFILE
+	err = viper.BindEnv("metrics-textfile")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_RESULT_FILE
+	err = viper.BindEnv("result-file")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_SHUTDOWN_TIMEOUT
+	err = viper.BindEnv("shutdown-timeout")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_ON_INTERRUPT
+	err = viper.BindEnv("on-interrupt")
+	if err != nil {
+		return err
+	}
+
+	// CODESHIP_CONFIG
+	err = viper.BindEnv("config")
+	if err != nil {
+		return err
+	}
+	return nil
+}
+
+// stringArray returns the values of a repeatable flag. viper renders
+// StringArray flags as a single string, so values given on the command line
+// are read from the flag itself. Values from the environment are separated by
+// whitespace.
+func stringArray(key string) []string {
+	flag := pflag.Lookup(key)
+	if flag != nil && flag.Changed {
+		values, _ := pflag.CommandLine.GetStringArray(key)
+		return values
+	}
+
+	// not set at all, viper falls back to the rendered default of the flag
+	if s, ok := viper.Get(key).(string); ok && flag != nil && s == flag.DefValue {
+		return nil
+	}
+	return viper.GetStringSlice(key)
+}
+
+// parseMaxWait parses --max-wait. viper.GetDuration would turn a value it
+// can't parse, or a bare number of seconds, into no limit or nanoseconds.
+func parseMaxWait(s string) (time.Duration, error) {
+	if s == "" {
+		return 0, nil
+	}
+	d, err := time.ParseDuration(s)
+	if err != nil || d < 0 {
+		return 0, configErrorf("invalid --max-wait %q: must be a duration such as 45m, or 0 to wait forever", s)
+	}
+	return d, nil
+}
+
+// newMonitor builds a monitor for org from the validated configuration.
+func newMonitor(org *codeship.Organization) (monitor, error) {
+	onTimeout := timeoutPolicy(viper.GetString("on-timeout"))
+	switch onTimeout {
+	case timeoutFail, timeoutProceed, timeoutStop:
+	default:
+		return monitor{}, configErrorf("invalid --on-timeout %q: must be fail, proceed or stop", onTimeout)
+	}
+
+	onNewer := newerBuildPolicy(viper.GetString("on-newer-build"))
+	switch onNewer {
+	case newerIgnore, newerStop, newerExit:
+	default:
+		return monitor{}, configErrorf("invalid --on-newer-build %q: must be ignore, stop or exit", onNewer)
+	}
+
+	unknownState, err := parseBuildState(viper.GetString("unknown-status"))
+	if err != nil {
+		return monitor{}, configError(err.Error())
+	}
+
+	order := buildOrder(viper.GetString("order"))
+	switch order {
+	case orderQueued, orderAllocated, orderAncestry:
+	default:
+		return monitor{}, configErrorf("invalid --order %q: must be queued, allocated or ancestry", order)
+	}
+
+	maxWait, err := parseMaxWait(viper.GetString("max-wait"))
+	if err != nil {
+		return monitor{}, err
+	}
+
+	maxConcurrent := viper.GetInt("max-concurrent")
+	if maxConcurrent < 1 {
+		return monitor{}, configError("--max-concurrent must be at least 1")
+	}
+
+	lock := viper.GetString("lock")
+	lockProjects := viper.GetStringSlice("lock-projects")
+	if lock != "" && len(lockProjects) == 0 {
+		return monitor{}, configError("--lock-projects required with --lock")
+	}
+
+	branchGroups, err := parseBranchGroups(stringArray("branch-group"))
+	if err != nil {
+		return monitor{}, configError(err.Error())
+	}
+
+	poll := backoff{
+		interval:    viper.GetDuration("poll-interval"),
+		maxInterval: viper.GetDuration("poll-max-interval"),
+		multiplier:  viper.GetFloat64("poll-multiplier"),
+		jitter:      viper.GetFloat64("poll-jitter"),
+	}
+	if poll.interval <= 0 {
+		return monitor{}, configError("--poll-interval must be positive")
+	}
+	if poll.multiplier < 1 {
+		return monitor{}, configError("--poll-multiplier must be at least 1")
+	}
+	if poll.jitter < 0 || poll.jitter > 1 {
+		return monitor{}, configError("--poll-jitter must be between 0 and 1")
+	}
+
+	maxRetries := viper.GetInt("max-retries")
+	if maxRetries < 0 {
+		return monitor{}, configError("--max-retries must not be negative")
+	}
+
+	config, err := loadConfigFile()
+	if err != nil {
+		return monitor{}, err
+	}
+
+	// every API call goes through api so it shows up in the metrics
+	api := instrumentedOrganization{org: org}
+
+	return monitor{
+		buildGetter:    api,
+		buildStopper:   api,
+		buildCreator:   api,
+		buildRestarter: api,
+		stepLister:     api,
+		pipelineLister: api,
+		projectGetter:  api,
+		maxWait:        maxWait,
+		onTimeout:      onTimeout,
+		poll:           poll,
+		maxRetries:     maxRetries,
+		retryBackoff:   defaultRetryBackoff,
+		dryRun:         viper.GetBool("dry-run"),
+		supersede:      viper.GetBool("supersede"),
+		onNewer:        onNewer,
+		requireSuccess: viper.GetBool("require-previous-success"),
+		ignoreStopped:  viper.GetBool("ignore-stopped"),
+		unknownState:   unknownState,
+		order:          order,
+		isAncestor:     cachedIsAncestor(gitIsAncestor),
+		maxConcurrent:  maxConcurrent,
+		lock:           lock,
+		lockProjects:   lockProjects,
+		branchGroups:   branchGroups,
+		steps:          viper.GetStringSlice("steps"),
+		pipeline:       viper.GetString("pipeline"),
+		projectTypes:   make(map[string]codeship.ProjectType),
+		report:         newWaitReport(),
+		config:         config,
+	}, nil
+}
+
+// configureLogger sets up the log format and level from the configuration.
+func configureLogger() error {
+	format := logFormat(viper.GetString("log-format"))
+	switch format {
+	case logText, logJSON:
+	default:
+		return configErrorf("invalid --log-format %q: must be text or json", format)
+	}
+
+	level, err := parseLogLevel(viper.GetString("log-level"))
+	if err != nil {
+		return configError(err.Error())
+	}
+
+	logger.format = format
+	logger.level = level
+	return nil
+}
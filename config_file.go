@@ -0,0 +1,408 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// defaultConfigFile is read from the working directory when --config isn't
+// given and it exists.
+const defaultConfigFile = "build-waiter.yml"
+
+// waitMode is how a build waits on the builds ahead of it.
+type waitMode string
+
+const (
+	modeSerialize waitMode = "serialize"
+	modeSupersede waitMode = "supersede"
+	modeSemaphore waitMode = "semaphore"
+)
+
+// failurePolicy is what happens when a build ahead of ours fails.
+type failurePolicy string
+
+const (
+	failureProceed           failurePolicy = "proceed"
+	failureFail              failurePolicy = "fail"
+	failureFailUnlessStopped failurePolicy = "fail-unless-stopped"
+)
+
+// policy holds the settings of a section of the config file. Settings the
+// section doesn't have are nil.
+type policy struct {
+	mode            *waitMode
+	maxConcurrent   *int
+	maxWait         *time.Duration
+	onTimeout       *timeoutPolicy
+	pollInterval    *time.Duration
+	pollMaxInterval *time.Duration
+	onFailure       *failurePolicy
+}
+
+// branchPolicy overrides the defaults for branches matching pattern.
+type branchPolicy struct {
+	pattern string
+	match   branchPattern
+	policy
+}
+
+// configFile is a parsed build-waiter.yml:
+//
+//	defaults:
+//	  mode: serialize
+//	  max-wait: 45m
+//	branches:
+//	  - branch: release/*
+//	    mode: semaphore
+//	    max-concurrent: 2
+type configFile struct {
+	path     string
+	defaults policy
+	branches []branchPolicy
+}
+
+// policyKeys are the keys allowed in defaults and in every entry of
+// branches, in order.
+var policyKeys = []string{
+	"max-concurrent",
+	"max-wait",
+	"mode",
+	"on-failure",
+	"on-timeout",
+	"poll-interval",
+	"poll-max-interval",
+}
+
+// isPolicyKey reports whether key is one of policyKeys.
+func isPolicyKey(key string) bool {
+	for _, k := range policyKeys {
+		if k == key {
+			return true
+		}
+	}
+	return false
+}
+
+// settingKeys returns the keys of a section of settings in order, so the
+// first offending key is always the same one.
+func settingKeys(settings map[string]interface{}) []string {
+	keys := make([]string, 0, len(settings))
+	for k := range settings {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// loadConfigFile reads the file given with --config, or build-waiter.yml if
+// it exists. It returns nil if there is no config file.
+func loadConfigFile() (*configFile, error) {
+	path := viper.GetString("config")
+	if path == "" {
+		if _, err := os.Stat(defaultConfigFile); err != nil {
+			return nil, nil
+		}
+		path = defaultConfigFile
+	}
+
+	return readConfigFile(path)
+}
+
+// readConfigFile reads and validates the config file at path. Its format is
+// picked by extension.
+func readConfigFile(path string) (*configFile, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	if err := v.ReadInConfig(); err != nil {
+		return nil, configErrorf("%s: %v", path, err)
+	}
+
+	return parseConfigFile(path, v.AllSettings())
+}
+
+// parseConfigFile validates the settings read from path. Errors name the
+// offending key.
+func parseConfigFile(path string, settings map[string]interface{}) (*configFile, error) {
+	c := &configFile{path: path}
+
+	for _, key := range settingKeys(settings) {
+		value := settings[key]
+		switch key {
+		case "defaults":
+			section, ok := toStringMap(value)
+			if !ok {
+				return nil, configErrorf("%s: defaults: must be a mapping", path)
+			}
+			p, err := parsePolicy(path, "defaults", section)
+			if err != nil {
+				return nil, err
+			}
+			c.defaults = p
+		case "branches":
+			entries, ok := value.([]interface{})
+			if !ok {
+				return nil, configErrorf("%s: branches: must be a list", path)
+			}
+			for i, entry := range entries {
+				b, err := parseBranchPolicy(path, fmt.Sprintf("branches[%d]", i), entry)
+				if err != nil {
+					return nil, err
+				}
+				c.branches = append(c.branches, b)
+			}
+		default:
+			return nil, configErrorf("%s: %s: unknown key, must be defaults or branches", path, key)
+		}
+	}
+
+	// every branch is run with the defaults merged in, so check the result
+	if err := c.defaults.validate(path, "defaults"); err != nil {
+		return nil, err
+	}
+	for i, b := range c.branches {
+		if err := c.defaults.merge(b.policy).validate(path, fmt.Sprintf("branches[%d]", i)); err != nil {
+			return nil, err
+		}
+	}
+
+	return c, nil
+}
+
+func parseBranchPolicy(path, prefix string, entry interface{}) (branchPolicy, error) {
+	section, ok := toStringMap(entry)
+	if !ok {
+		return branchPolicy{}, configErrorf("%s: %s: must be a mapping", path, prefix)
+	}
+
+	pattern, ok := section["branch"].(string)
+	if !ok || pattern == "" {
+		return branchPolicy{}, configErrorf("%s: %s.branch: required, a glob or a regular expression prefixed with re:", path, prefix)
+	}
+	match, err := parseBranchPattern(pattern)
+	if err != nil {
+		return branchPolicy{}, configErrorf("%s: %s.branch: %v", path, prefix, err)
+	}
+	delete(section, "branch")
+
+	p, err := parsePolicy(path, prefix, section)
+	if err != nil {
+		return branchPolicy{}, err
+	}
+	return branchPolicy{pattern: pattern, match: match, policy: p}, nil
+}
+
+// parsePolicy parses a section of settings found at prefix.
+func parsePolicy(path, prefix string, section map[string]interface{}) (policy, error) {
+	var p policy
+
+	for _, key := range settingKeys(section) {
+		value := section[key]
+		fail := func(format string, args ...interface{}) (policy, error) {
+			return policy{}, configErrorf("%s: %s.%s: %s", path, prefix, key, fmt.Sprintf(format, args...))
+		}
+
+		if !isPolicyKey(key) {
+			return fail("unknown key, must be one of %s", strings.Join(policyKeys, ", "))
+		}
+
+		switch key {
+		case "mode":
+			mode := waitMode(fmt.Sprint(value))
+			switch mode {
+			case modeSerialize, modeSupersede, modeSemaphore:
+			default:
+				return fail("must be serialize, supersede or semaphore, got %q", value)
+			}
+			p.mode = &mode
+		case "max-concurrent":
+			n, ok := toInt(value)
+			if !ok || n < 1 {
+				return fail("must be a whole number of at least 1, got %v", value)
+			}
+			p.maxConcurrent = &n
+		case "max-wait", "poll-interval", "poll-max-interval":
+			s, ok := value.(string)
+			d, err := time.ParseDuration(s)
+			if !ok || err != nil || d <= 0 {
+				return fail("must be a positive duration such as 30s or 45m, got %v", value)
+			}
+			switch key {
+			case "max-wait":
+				p.maxWait = &d
+			case "poll-interval":
+				p.pollInterval = &d
+			default:
+				p.pollMaxInterval = &d
+			}
+		case "on-timeout":
+			onTimeout := timeoutPolicy(fmt.Sprint(value))
+			switch onTimeout {
+			case timeoutFail, timeoutProceed, timeoutStop:
+			default:
+				return fail("must be fail, proceed or stop, got %q", value)
+			}
+			p.onTimeout = &onTimeout
+		case "on-failure":
+			onFailure := failurePolicy(fmt.Sprint(value))
+			switch onFailure {
+			case failureProceed, failureFail, failureFailUnlessStopped:
+			default:
+				return fail("must be proceed, fail or fail-unless-stopped, got %q", value)
+			}
+			p.onFailure = &onFailure
+		}
+	}
+
+	return p, nil
+}
+
+// validate checks the settings that depend on each other.
+func (p policy) validate(path, prefix string) error {
+	if p.maxConcurrent == nil || p.mode == nil {
+		if p.mode != nil && *p.mode == modeSemaphore {
+			return configErrorf("%s: %s.max-concurrent: required with mode semaphore", path, prefix)
+		}
+		return nil
+	}
+	if *p.mode != modeSemaphore {
+		return configErrorf("%s: %s.max-concurrent: only applies to mode semaphore, not %s", path, prefix, *p.mode)
+	}
+	return nil
+}
+
+// merge returns p with the settings of o on top.
+func (p policy) merge(o policy) policy {
+	if o.mode != nil {
+		p.mode = o.mode
+		// a branch switching modes doesn't inherit the semaphore size
+		if *o.mode != modeSemaphore && o.maxConcurrent == nil {
+			p.maxConcurrent = nil
+		}
+	}
+	if o.maxConcurrent != nil {
+		p.maxConcurrent = o.maxConcurrent
+	}
+	if o.maxWait != nil {
+		p.maxWait = o.maxWait
+	}
+	if o.onTimeout != nil {
+		p.onTimeout = o.onTimeout
+	}
+	if o.pollInterval != nil {
+		p.pollInterval = o.pollInterval
+	}
+	if o.pollMaxInterval != nil {
+		p.pollMaxInterval = o.pollMaxInterval
+	}
+	if o.onFailure != nil {
+		p.onFailure = o.onFailure
+	}
+	return p
+}
+
+// policyFor returns the defaults with the first entry of branches matching
+// branch on top, and the pattern of that entry.
+func (c *configFile) policyFor(branch string) (policy, string) {
+	if c == nil {
+		return policy{}, ""
+	}
+	for _, b := range c.branches {
+		if b.match.match(branch) {
+			return c.defaults.merge(b.policy), b.pattern
+		}
+	}
+	return c.defaults, ""
+}
+
+// withPolicy returns m with the policy for branch from the config file
+// applied. Flags and environment variables that are set take precedence.
+func (m monitor) withPolicy(branch string) monitor {
+	if m.config == nil {
+		return m
+	}
+
+	p, pattern := m.config.policyFor(branch)
+	if pattern != "" {
+		logInfo(eventPolicy, fields{"branch": branch, "pattern": pattern, "config": m.config.path}, "Using the policy for branches %s from %s", pattern, m.config.path)
+	}
+
+	if p.mode != nil {
+		if !flagSet("supersede") {
+			m.supersede = *p.mode == modeSupersede
+		}
+		if *p.mode == modeSerialize && !flagSet("max-concurrent") {
+			m.maxConcurrent = 1
+		}
+	}
+	if p.maxConcurrent != nil && !flagSet("max-concurrent") {
+		m.maxConcurrent = *p.maxConcurrent
+	}
+	if p.maxWait != nil && !flagSet("max-wait") {
+		m.maxWait = *p.maxWait
+	}
+	if p.onTimeout != nil && !flagSet("on-timeout") {
+		m.onTimeout = *p.onTimeout
+	}
+	if p.pollInterval != nil && !flagSet("poll-interval") {
+		m.poll.interval = *p.pollInterval
+	}
+	if p.pollMaxInterval != nil && !flagSet("poll-max-interval") {
+		m.poll.maxInterval = *p.pollMaxInterval
+	}
+	if p.onFailure != nil {
+		if !flagSet("require-previous-success") {
+			m.requireSuccess = *p.onFailure != failureProceed
+		}
+		if !flagSet("ignore-stopped") {
+			m.ignoreStopped = *p.onFailure == failureFailUnlessStopped
+		}
+	}
+
+	return m
+}
+
+// flagSet reports whether key was given on the command line or in the
+// environment.
+func flagSet(key string) bool {
+	if flag := pflag.Lookup(key); flag != nil && flag.Changed {
+		return true
+	}
+	_, ok := os.LookupEnv("CODESHIP_" + strings.ToUpper(strings.Replace(key, "-", "_", -1)))
+	return ok
+}
+
+// toStringMap converts a mapping as decoded from YAML, TOML or JSON.
+func toStringMap(v interface{}) (map[string]interface{}, bool) {
+	switch v := v.(type) {
+	case map[string]interface{}:
+		return v, true
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(v))
+		for k, value := range v {
+			m[fmt.Sprint(k)] = value
+		}
+		return m, true
+	}
+	return nil, false
+}
+
+// toInt converts a whole number as decoded from YAML, TOML or JSON.
+func toInt(v interface{}) (int, bool) {
+	switch v := v.(type) {
+	case int:
+		return v, true
+	case int64:
+		return int(v), true
+	case float64:
+		if v == float64(int(v)) {
+			return int(v), true
+		}
+	}
+	return 0, false
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func writeConfigFile(t *testing.T, name, content string) (string, func()) {
+	dir, err := ioutil.TempDir("", "build-waiter")
+	require.NoError(t, err)
+
+	path := filepath.Join(dir, name)
+	require.NoError(t, ioutil.WriteFile(path, []byte(content), 0644))
+	return path, func() { os.RemoveAll(dir) }
+}
+
+const testConfigFile = `
+defaults:
+  mode: serialize
+  max-wait: 45m
+  poll-interval: 10s
+  on-failure: fail
+branches:
+  - branch: release/*
+    mode: semaphore
+    max-concurrent: 2
+    on-failure: fail-unless-stopped
+  - branch: re:^feature/
+    mode: supersede
+    max-wait: 5m
+`
+
+func TestReadConfigFile(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "build-waiter.yml", testConfigFile)
+	defer cleanup()
+
+	config, err := readConfigFile(path)
+	require.NoError(t, err)
+
+	p, pattern := config.policyFor("release/1.0")
+	assert.Equal(t, "release/*", pattern)
+	assert.Equal(t, modeSemaphore, *p.mode)
+	assert.Equal(t, 2, *p.maxConcurrent)
+	assert.Equal(t, 45*time.Minute, *p.maxWait)
+	assert.Equal(t, 10*time.Second, *p.pollInterval)
+	assert.Equal(t, failureFailUnlessStopped, *p.onFailure)
+
+	p, pattern = config.policyFor("feature/login")
+	assert.Equal(t, "re:^feature/", pattern)
+	assert.Equal(t, modeSupersede, *p.mode)
+	assert.Nil(t, p.maxConcurrent)
+	assert.Equal(t, 5*time.Minute, *p.maxWait)
+
+	p, pattern = config.policyFor("master")
+	assert.Equal(t, "", pattern)
+	assert.Equal(t, modeSerialize, *p.mode)
+	assert.Equal(t, failureFail, *p.onFailure)
+}
+
+func TestReadConfigFileJSON(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "build-waiter.json", `{"branches": [{"branch": "main", "mode": "semaphore", "max-concurrent": 3}]}`)
+	defer cleanup()
+
+	config, err := readConfigFile(path)
+	require.NoError(t, err)
+
+	p, _ := config.policyFor("main")
+	assert.Equal(t, 3, *p.maxConcurrent)
+}
+
+func TestReadConfigFileErrors(t *testing.T) {
+	testCases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{
+			name:    "unknown top level key",
+			content: "default:\n  mode: serialize\n",
+			err:     "default: unknown key, must be defaults or branches",
+		}, {
+			name:    "invalid mode",
+			content: "defaults:\n  mode: queue\n",
+			err:     `defaults.mode: must be serialize, supersede or semaphore, got "queue"`,
+		}, {
+			name:    "unknown policy key",
+			content: "defaults:\n  max_wait: 5m\n",
+			err:     "defaults.max_wait: unknown key, must be one of max-concurrent, max-wait, mode, on-failure, on-timeout, poll-interval, poll-max-interval",
+		}, {
+			name:    "invalid duration",
+			content: "branches:\n  - branch: master\n  - branch: main\n    poll-interval: 5x\n",
+			err:     "branches[1].poll-interval: must be a positive duration such as 30s or 45m, got 5x",
+		}, {
+			name:    "missing branch",
+			content: "branches:\n  - mode: serialize\n",
+			err:     "branches[0].branch: required, a glob or a regular expression prefixed with re:",
+		}, {
+			name:    "invalid branch pattern",
+			content: "branches:\n  - branch: \"re:(\"\n",
+			err:     `branches[0].branch: invalid branch pattern "re:("`,
+		}, {
+			name:    "invalid max-concurrent",
+			content: "defaults:\n  mode: semaphore\n  max-concurrent: 0\n",
+			err:     "defaults.max-concurrent: must be a whole number of at least 1, got 0",
+		}, {
+			name:    "semaphore without max-concurrent",
+			content: "branches:\n  - branch: master\n    mode: semaphore\n",
+			err:     "branches[0].max-concurrent: required with mode semaphore",
+		}, {
+			name:    "max-concurrent without semaphore",
+			content: "defaults:\n  mode: serialize\nbranches:\n  - branch: master\n    max-concurrent: 2\n",
+			err:     "branches[0].max-concurrent: only applies to mode semaphore, not serialize",
+		}, {
+			name:    "invalid failure policy",
+			content: "defaults:\n  on-failure: retry\n",
+			err:     `defaults.on-failure: must be proceed, fail or fail-unless-stopped, got "retry"`,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			path, cleanup := writeConfigFile(t, "build-waiter.yml", tc.content)
+			defer cleanup()
+
+			_, err := readConfigFile(path)
+			require.Error(t, err)
+			_, ok := err.(configError)
+			assert.True(t, ok)
+			assert.Contains(t, err.Error(), path+": "+tc.err)
+		})
+	}
+}
+
+func TestWithPolicy(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "build-waiter.yml", testConfigFile)
+	defer cleanup()
+
+	config, err := readConfigFile(path)
+	require.NoError(t, err)
+
+	base := monitor{config: config, maxConcurrent: 1, poll: backoff{interval: defaultPollInterval}}
+
+	m := base.withPolicy("release/1.0")
+	assert.False(t, m.supersede)
+	assert.Equal(t, 2, m.maxConcurrent)
+	assert.Equal(t, 45*time.Minute, m.maxWait)
+	assert.Equal(t, 10*time.Second, m.poll.interval)
+	assert.True(t, m.requireSuccess)
+	assert.True(t, m.ignoreStopped)
+
+	m = base.withPolicy("feature/login")
+	assert.True(t, m.supersede)
+	assert.Equal(t, 5*time.Minute, m.maxWait)
+
+	m = base.withPolicy("master")
+	assert.False(t, m.supersede)
+	assert.Equal(t, 1, m.maxConcurrent)
+	assert.True(t, m.requireSuccess)
+	assert.False(t, m.ignoreStopped)
+
+	m = monitor{maxWait: time.Minute}.withPolicy("master")
+	assert.Equal(t, time.Minute, m.maxWait)
+}
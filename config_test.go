@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestParseMaxWait(t *testing.T) {
+	testCases := []struct {
+		value   string
+		maxWait time.Duration
+		err     bool
+	}{
+		{value: "", maxWait: 0},
+		{value: "0s", maxWait: 0},
+		{value: "45m", maxWait: 45 * time.Minute},
+		{value: "45x", err: true},
+		{value: "2700", err: true},
+		{value: "-5m", err: true},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.value, func(t *testing.T) {
+			maxWait, err := parseMaxWait(tc.value)
+			if tc.err {
+				_, ok := err.(configError)
+				require.True(t, ok)
+				return
+			}
+			require.NoError(t, err)
+			assert.Equal(t, tc.maxWait, maxWait)
+		})
+	}
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"context"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+type projectGetter interface {
+	GetProject(ctx context.Context, projectUUID string) (codeship.Project, codeship.Response, error)
+}
+
+// passedGate reports whether b no longer holds us back: it has finished or
+// the part of it we gate on has finished. That is the gated steps on Pro
+// projects and the gated pipeline on Basic projects.
+func (m monitor) passedGate(ctx context.Context, b codeship.Build) (bool, error) {
+	finished, err := m.buildFinished(ctx, b)
+	if err != nil || finished {
+		return finished, err
+	}
+
+	if len(m.steps) == 0 && m.pipeline == "" {
+		return false, nil
+	}
+
+	projectType, err := m.projectType(ctx, b.ProjectUUID)
+	if err != nil {
+		return false, err
+	}
+
+	switch {
+	case projectType == codeship.ProjectTypePro && len(m.steps) > 0:
+		return m.stepsFinished(ctx, b)
+	case projectType == codeship.ProjectTypeBasic && m.pipeline != "":
+		return m.pipelineFinished(ctx, b)
+	}
+
+	return false, nil
+}
+
+// projectType returns whether a project is Basic or Pro, fetching it once.
+func (m monitor) projectType(ctx context.Context, projectUUID string) (codeship.ProjectType, error) {
+	if t, ok := m.projectTypes[projectUUID]; ok {
+		return t, nil
+	}
+
+	var project codeship.Project
+	err := m.retry(ctx, "get project "+projectUUID, func() (codeship.Response, error) {
+		var (
+			resp codeship.Response
+			err  error
+		)
+		project, resp, err = m.GetProject(ctx, projectUUID)
+		return resp, err
+	})
+	if err != nil {
+		return 0, err
+	}
+
+	switch {
+	case project.Type == codeship.ProjectTypePro && len(m.steps) == 0:
+		logWarn(eventWarning, fields{"project_uuid": projectUUID}, "Warning: project %s is a Pro project, --pipeline is ignored and whole builds are waited on", projectUUID)
+	case project.Type == codeship.ProjectTypeBasic && m.pipeline == "":
+		logWarn(eventWarning, fields{"project_uuid": projectUUID}, "Warning: project %s is a Basic project, --steps is ignored and whole builds are waited on", projectUUID)
+	}
+
+	if m.projectTypes != nil {
+		m.projectTypes[projectUUID] = project.Type
+	}
+	return project.Type, nil
+}
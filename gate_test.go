@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockProjectGetter struct {
+	projectType codeship.ProjectType
+	calls       *int
+}
+
+func (m mockProjectGetter) GetProject(ctx context.Context, projectUUID string) (codeship.Project, codeship.Response, error) {
+	if m.calls != nil {
+		*m.calls++
+	}
+	return codeship.Project{
+		UUID: projectUUID,
+		Type: m.projectType,
+	}, codeship.Response{}, nil
+}
+
+type mockPipelineLister map[string][]codeship.BuildPipeline
+
+func (m mockPipelineLister) ListBuildPipelines(ctx context.Context, projectUUID, buildUUID string, opts ...codeship.PaginationOption) (codeship.BuildPipelines, codeship.Response, error) {
+	return codeship.BuildPipelines{
+		Pipelines: m[buildUUID],
+	}, codeship.Response{}, nil
+}
+
+func TestPassedGateProjectType(t *testing.T) {
+	steps := mockStepLister{
+		"build-uuid": {
+			{Name: "deploy-staging", Status: "success"},
+		},
+	}
+	pipelines := mockPipelineLister{
+		"build-uuid": {
+			{Type: "test", Status: "success"},
+			{Type: "deployment", Status: "running"},
+		},
+	}
+
+	testCases := []struct {
+		name        string
+		projectType codeship.ProjectType
+		steps       []string
+		pipeline    string
+		passed      bool
+	}{
+		{
+			name:        "pro project gated on steps",
+			projectType: codeship.ProjectTypePro,
+			steps:       []string{"deploy-staging"},
+			pipeline:    "test",
+			passed:      true,
+		}, {
+			name:        "basic project gated on finished pipeline",
+			projectType: codeship.ProjectTypeBasic,
+			steps:       []string{"deploy-staging"},
+			pipeline:    "test",
+			passed:      true,
+		}, {
+			name:        "basic project gated on running pipeline",
+			projectType: codeship.ProjectTypeBasic,
+			pipeline:    "deployment",
+		}, {
+			name:        "basic project gated on missing pipeline",
+			projectType: codeship.ProjectTypeBasic,
+			pipeline:    "release",
+		}, {
+			name:        "basic project without pipeline",
+			projectType: codeship.ProjectTypeBasic,
+			steps:       []string{"deploy-staging"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			calls := 0
+			monitor := &monitor{
+				buildGetter: mockBuildGetter{
+					buildStatus: "testing",
+				},
+				stepLister:     steps,
+				pipelineLister: pipelines,
+				projectGetter: mockProjectGetter{
+					projectType: tc.projectType,
+					calls:       &calls,
+				},
+				steps:        tc.steps,
+				pipeline:     tc.pipeline,
+				projectTypes: make(map[string]codeship.ProjectType),
+			}
+
+			for i := 0; i < 2; i++ {
+				passed, err := monitor.passedGate(context.TODO(), codeship.Build{UUID: "build-uuid", ProjectUUID: "project-uuid"})
+				require.NoError(t, err)
+				assert.Equal(t, tc.passed, passed)
+			}
+			assert.Equal(t, 1, calls)
+		})
+	}
+}
+
+func TestWaitOnPreviousBuildsPipeline(t *testing.T) {
+	now := time.Now()
+	monitor := &monitor{
+		buildGetter: mockBuildList{
+			builds: []codeship.Build{
+				{UUID: "ahead", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)},
+				{UUID: "self", Status: "testing", Branch: "test-branch", QueuedAt: now},
+			},
+		},
+		pipelineLister: mockPipelineLister{
+			"ahead": {
+				{Type: "deployment", Status: "success"},
+			},
+		},
+		projectGetter: mockProjectGetter{
+			projectType: codeship.ProjectTypeBasic,
+		},
+		order:     orderQueued,
+		pipeline:  "deployment",
+		maxWait:   10 * time.Millisecond,
+		onTimeout: timeoutFail,
+	}
+
+	err := monitor.waitOnPreviousBuilds(context.TODO(), "project-uuid", "self", "test-branch")
+	require.NoError(t, err)
+}
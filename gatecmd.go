@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/spf13/viper"
+)
+
+const commandGate = "gate"
+
+// clockSkew is how far our clock and Codeship's may be apart when looking
+// for a build we just created.
+const clockSkew = time.Minute
+
+type buildCreator interface {
+	CreateBuild(ctx context.Context, projectUUID, ref, commitSha string) (bool, codeship.Response, error)
+}
+
+// runGate blocks until the newest build of a branch, or of a commit on the
+// branch, is green and fails if it isn't.
+func runGate(ctx context.Context, m monitor) error {
+	projectUUID, err := commandProject()
+	if err != nil {
+		return err
+	}
+
+	// a commit alone is enough to find its build, but not to create one
+	branch := viper.GetString("branch")
+	commit := viper.GetString("commit")
+	create := viper.GetBool("create")
+	if branch == "" && (commit == "" || create) {
+		return configError("--branch required without --commit or with --create")
+	}
+
+	if branch != "" {
+		m = m.withPolicy(branch)
+	}
+
+	build, found, err := m.findBuild(ctx, projectUUID, branch, commit, time.Time{})
+	if err != nil {
+		return err
+	}
+	if !found {
+		if branch == "" {
+			return fmt.Errorf("no build found for commit %s", commit)
+		}
+		if commit == "" || !create {
+			return fmt.Errorf("no build found for commit %q on branch %s", commit, branch)
+		}
+
+		build, err = m.createBuild(ctx, projectUUID, branch, commit)
+		if err != nil {
+			return err
+		}
+	}
+
+	if branch == "" {
+		branch = build.Branch
+		m = m.withPolicy(branch)
+	}
+
+	logInfo(eventWaiting, fields{"build_uuid": build.UUID, "commit_sha": build.CommitSha, "branch": branch}, "Gating on build %s for commit %s on branch %s", build.UUID, build.CommitSha, branch)
+
+	build, err = m.waitForBuild(ctx, projectUUID, build.UUID)
+	if err != nil {
+		return err
+	}
+
+	if m.state(build) != stateSucceeded {
+		return buildFailedError{build: build}
+	}
+
+	logInfo(eventBuildFinished, fields{"build_uuid": build.UUID, "commit_sha": build.CommitSha, "branch": branch, "status": build.Status}, "Branch %s is green at commit %s", branch, build.CommitSha)
+	return nil
+}
+
+// createBuild triggers a build of commit on branch and waits for it to show
+// up in the list of builds.
+func (m monitor) createBuild(ctx context.Context, projectUUID, branch, commit string) (codeship.Build, error) {
+	// the created build is queued after this, give or take the difference
+	// between our clock and Codeship's
+	since := time.Now().Add(-clockSkew)
+
+	// creating a build isn't idempotent: only retry when the rate limit turned
+	// the request away, never when it may have reached Codeship
+	err := m.retryIf(ctx, "create build for commit "+commit, rateLimited, func() (codeship.Response, error) {
+		_, resp, err := m.CreateBuild(ctx, projectUUID, "heads/"+branch, commit)
+		return resp, err
+	})
+	if err != nil {
+		return codeship.Build{}, err
+	}
+
+	logInfo(eventBuildCreated, fields{"commit_sha": commit, "branch": branch}, "Created build for commit %s on branch %s", commit, branch)
+
+	var timeout <-chan time.Time
+	if m.maxWait > 0 {
+		timer := time.NewTimer(m.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	poll := m.poll
+	for {
+		build, found, err := m.findBuild(ctx, projectUUID, branch, commit, since)
+		if err != nil {
+			return codeship.Build{}, err
+		}
+		if found {
+			return build, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return codeship.Build{}, interruptedError{}
+		case <-timeout:
+			return codeship.Build{}, timeoutError{maxWait: m.maxWait, build: codeship.Build{Branch: branch, CommitSha: commit}}
+		case <-time.After(poll.next()):
+		}
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// mockBuildCreator adds a build to the list served by its mockBuildList for
+// every created build. Calls fail with errs first, one error per call.
+type mockBuildCreator struct {
+	*mockBuildList
+	refs []string
+	errs []error
+}
+
+func (m *mockBuildCreator) CreateBuild(ctx context.Context, projectUUID, ref, commitSha string) (bool, codeship.Response, error) {
+	m.refs = append(m.refs, ref)
+	if len(m.errs) > 0 {
+		err := m.errs[0]
+		m.errs = m.errs[1:]
+		return false, codeship.Response{}, err
+	}
+	m.builds = append([]codeship.Build{{UUID: "created", Branch: "master", CommitSha: commitSha, Status: "waiting"}}, m.builds...)
+	return true, codeship.Response{}, nil
+}
+
+func TestCreateBuild(t *testing.T) {
+	builds := &mockBuildList{
+		builds: []codeship.Build{
+			{UUID: "old", Branch: "master", CommitSha: "abc", Status: "success"},
+		},
+	}
+	creator := &mockBuildCreator{mockBuildList: builds}
+
+	monitor := &monitor{
+		buildGetter:  builds,
+		buildCreator: creator,
+		poll:         backoff{interval: time.Millisecond},
+	}
+
+	build, err := monitor.createBuild(context.TODO(), "project-uuid", "master", "def")
+	require.NoError(t, err)
+	assert.Equal(t, "created", build.UUID)
+	assert.Equal(t, []string{"heads/master"}, creator.refs)
+}
+
+func TestCreateBuildRetries(t *testing.T) {
+	testCases := []struct {
+		name  string
+		err   error
+		calls int
+		fails bool
+	}{
+		{
+			name:  "rate limited",
+			err:   codeship.ErrRateLimitExceeded,
+			calls: 2,
+		}, {
+			name:  "network error",
+			err:   &net.OpError{Op: "read", Err: errors.New("connection reset by peer")},
+			calls: 1,
+			fails: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builds := &mockBuildList{}
+			creator := &mockBuildCreator{mockBuildList: builds, errs: []error{tc.err}}
+
+			monitor := &monitor{
+				buildGetter:  builds,
+				buildCreator: creator,
+				poll:         backoff{interval: time.Millisecond},
+				maxRetries:   3,
+				retryBackoff: backoff{interval: time.Millisecond},
+			}
+
+			_, err := monitor.createBuild(context.TODO(), "project-uuid", "master", "def")
+			if tc.fails {
+				_, ok := err.(apiError)
+				require.True(t, ok)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Len(t, creator.refs, tc.calls)
+		})
+	}
+}
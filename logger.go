@@ -0,0 +1,149 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+	"time"
+)
+
+// logFormat is the format log events are written in, see --log-format.
+type logFormat string
+
+const (
+	logText logFormat = "text"
+	logJSON logFormat = "json"
+)
+
+// logLevel is the severity of a log event, see --log-level.
+type logLevel int
+
+const (
+	levelDebug logLevel = iota
+	levelInfo
+	levelWarn
+	levelError
+)
+
+var logLevelNames = map[logLevel]string{
+	levelDebug: "debug",
+	levelInfo:  "info",
+	levelWarn:  "warn",
+	levelError: "error",
+}
+
+func (l logLevel) String() string {
+	return logLevelNames[l]
+}
+
+// parseLogLevel parses the name of a level, as used for --log-level.
+func parseLogLevel(name string) (logLevel, error) {
+	for l, n := range logLevelNames {
+		if n == name {
+			return l, nil
+		}
+	}
+	return 0, fmt.Errorf("invalid --log-level %q: must be debug, info, warn or error", name)
+}
+
+// Event types carried by JSON log events so they can be told apart without
+// parsing the message.
+const (
+	eventQueueComputed       = "queue_computed"
+	eventWaiting             = "waiting"
+	eventPredecessorFinished = "predecessor_finished"
+	eventPredecessorFailed   = "predecessor_failed"
+	eventResumed             = "resumed"
+	eventTimeout             = "timeout"
+	eventSuperseded          = "superseded"
+	eventBuildStopped        = "build_stopped"
+	eventBuildCreated        = "build_created"
+	eventBuildFinished       = "build_finished"
+	eventAPIError            = "api_error"
+	eventInterrupted         = "interrupted"
+	eventSignal              = "signal"
+	eventCleanup             = "cleanup"
+	eventExit                = "exit"
+	eventPolicy              = "policy"
+	eventWarning             = "warning"
+	eventError               = "error"
+)
+
+// fields are the structured data attached to a log event.
+type fields map[string]interface{}
+
+// eventLogger writes log events either as the plain lines of the standard
+// logger or as one JSON object per line.
+type eventLogger struct {
+	mu     sync.Mutex
+	out    io.Writer
+	format logFormat
+	level  logLevel
+	now    func() time.Time
+}
+
+var logger = &eventLogger{
+	out:    os.Stderr,
+	format: logText,
+	level:  levelInfo,
+	now:    time.Now,
+}
+
+// log writes an event of the given type if level is enabled. The message is
+// built from format and args like log.Printf.
+func (l *eventLogger) log(level logLevel, event string, f fields, format string, args ...interface{}) {
+	if level < l.level {
+		return
+	}
+
+	msg := fmt.Sprintf(format, args...)
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.format != logJSON {
+		// just the message, like the standard logger without flags, so the
+		// text output stays stable
+		fmt.Fprintln(l.out, msg)
+		return
+	}
+
+	entry := make(map[string]interface{}, len(f)+4)
+	for k, v := range f {
+		entry[k] = v
+	}
+	entry["time"] = l.now().UTC().Format(time.RFC3339Nano)
+	entry["level"] = level.String()
+	entry["event"] = event
+	entry["msg"] = msg
+
+	line, err := json.Marshal(entry)
+	if err != nil {
+		fmt.Fprintf(l.out, `{"level":"error","event":%q,"msg":%q}`+"\n", eventError, err.Error())
+		return
+	}
+	fmt.Fprintf(l.out, "%s\n", line)
+}
+
+func logDebug(event string, f fields, format string, args ...interface{}) {
+	logger.log(levelDebug, event, f, format, args...)
+}
+
+func logInfo(event string, f fields, format string, args ...interface{}) {
+	logger.log(levelInfo, event, f, format, args...)
+}
+
+func logWarn(event string, f fields, format string, args ...interface{}) {
+	logger.log(levelWarn, event, f, format, args...)
+}
+
+func logError(event string, f fields, format string, args ...interface{}) {
+	logger.log(levelError, event, f, format, args...)
+}
+
+// elapsedSeconds is how long has passed since start, as logged in events.
+func elapsedSeconds(start time.Time) int64 {
+	return int64(time.Since(start) / time.Second)
+}
@@ -0,0 +1,63 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestEventLogger(t *testing.T) {
+	now := time.Date(2018, 6, 6, 12, 30, 0, 0, time.UTC)
+
+	t.Run("text", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := &eventLogger{out: &buf, format: logText, level: levelInfo, now: func() time.Time { return now }}
+
+		l.log(levelInfo, eventWaiting, fields{"position": 2}, "Waiting on build %s, position %d in queue", "1", 2)
+
+		assert.Equal(t, "Waiting on build 1, position 2 in queue\n", buf.String())
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := &eventLogger{out: &buf, format: logJSON, level: levelInfo, now: func() time.Time { return now }}
+
+		l.log(levelWarn, eventTimeout, fields{"blocking_uuid": "1", "elapsed_seconds": 90}, "Exceeded max wait")
+
+		var event map[string]interface{}
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &event))
+		assert.Equal(t, "2018-06-06T12:30:00Z", event["time"])
+		assert.Equal(t, "warn", event["level"])
+		assert.Equal(t, eventTimeout, event["event"])
+		assert.Equal(t, "Exceeded max wait", event["msg"])
+		assert.Equal(t, "1", event["blocking_uuid"])
+		assert.Equal(t, float64(90), event["elapsed_seconds"])
+	})
+
+	t.Run("level", func(t *testing.T) {
+		var buf bytes.Buffer
+		l := &eventLogger{out: &buf, format: logJSON, level: levelWarn, now: func() time.Time { return now }}
+
+		l.log(levelDebug, eventQueueComputed, nil, "queue")
+		l.log(levelInfo, eventWaiting, nil, "waiting")
+		assert.Equal(t, "", buf.String())
+
+		l.log(levelError, eventError, nil, "failed")
+		assert.Contains(t, buf.String(), `"event":"error"`)
+	})
+}
+
+func TestParseLogLevel(t *testing.T) {
+	for _, name := range []string{"debug", "info", "warn", "error"} {
+		level, err := parseLogLevel(name)
+		require.NoError(t, err)
+		assert.Equal(t, name, level.String())
+	}
+
+	_, err := parseLogLevel("verbose")
+	require.Error(t, err)
+}
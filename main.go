@@ -2,140 +2,346 @@ package main
 
 import (
 	"context"
-	"flag"
+	"fmt"
 	"log"
 	"os"
-	"os/signal"
-	"sort"
+	"strings"
 	"time"
 
 	codeship "github.com/codeship/codeship-go"
+	"github.com/pkg/errors"
 	"github.com/spf13/pflag"
 	"github.com/spf13/viper"
 )
 
-type allocatedAtSort []codeship.Build
+const (
+	// exitFailed is the exit code used when wait-for sees the build it
+	// waited on fail.
+	exitFailed = 1
 
-func (s allocatedAtSort) Len() int {
-	return len(s)
+	// exitTimeout is the exit code used when --max-wait is exceeded and the
+	// timeout policy is to fail.
+	exitTimeout = 2
+
+	// exitSuperseded is the exit code used when a newer build on the branch
+	// makes ours pointless.
+	exitSuperseded = 3
+
+	// exitPreviousFailed is the exit code used when a build ahead of ours
+	// failed and --require-previous-success is set.
+	exitPreviousFailed = 4
+
+	// exitConfig is the exit code used when the configuration is missing or
+	// invalid.
+	exitConfig = 5
+
+	// exitAuth is the exit code used when Codeship rejects the credentials
+	// or the organization.
+	exitAuth = 6
+
+	// exitAPI is the exit code used when a call to the Codeship API fails
+	// for good.
+	exitAPI = 7
+
+	// exitInterrupted is the exit code used when the waiter is interrupted
+	// before it is done, so an interrupted wait never looks like success.
+	exitInterrupted = 130
+)
+
+// configError is returned when the configuration is missing or invalid.
+type configError string
+
+func (e configError) Error() string {
+	return string(e)
+}
+
+func configErrorf(format string, args ...interface{}) error {
+	return configError(fmt.Sprintf(format, args...))
+}
+
+// interruptedError is returned when the waiter is interrupted before it is
+// done, by signal when it is known.
+type interruptedError struct {
+	signal os.Signal
+}
+
+func (e interruptedError) Error() string {
+	if e.signal == nil {
+		return "interrupted"
+	}
+	return "interrupted by " + e.signal.String()
+}
+
+// apiError is returned when a call to the Codeship API fails and isn't
+// retried any more.
+type apiError struct {
+	op  string
+	err error
+}
+
+func (e apiError) Error() string {
+	return e.op + ": " + e.err.Error()
+}
+
+// Cause returns the underlying error for errors.Cause.
+func (e apiError) Cause() error {
+	return e.err
+}
+
+type timeoutPolicy string
+
+const (
+	timeoutFail    timeoutPolicy = "fail"
+	timeoutProceed timeoutPolicy = "proceed"
+	timeoutStop    timeoutPolicy = "stop"
+)
+
+// timeoutError is returned when the maximum wait is exceeded while waiting
+// on a build ahead of ours.
+type timeoutError struct {
+	maxWait time.Duration
+	build   codeship.Build
+}
+
+func (e timeoutError) Error() string {
+	if e.build.UUID == "" {
+		return fmt.Sprintf("timed out after %s waiting on a build for commit %s", e.maxWait, e.build.CommitSha)
+	}
+	return fmt.Sprintf("timed out after %s waiting on build %s", e.maxWait, e.build.UUID)
 }
 
-func (s allocatedAtSort) Swap(i, j int) {
-	s[i], s[j] = s[j], s[i]
+// previousFailedError is returned when a build ahead of ours did not succeed
+// and --require-previous-success is set.
+type previousFailedError struct {
+	build codeship.Build
 }
 
-func (s allocatedAtSort) Less(i, j int) bool {
-	return s[i].AllocatedAt.Before(s[j].AllocatedAt)
+func (e previousFailedError) Error() string {
+	return fmt.Sprintf("previous build %s for commit %s finished with status %s", e.build.UUID, e.build.CommitSha, e.build.Status)
 }
 
 func main() {
 	log.SetFlags(0)
 
-	pflag.CommandLine.AddGoFlagSet(flag.CommandLine)
-	pflag.Parse()
-	err := viper.BindPFlags(pflag.CommandLine)
-	if err != nil {
-		log.Fatal(err)
+	defineFlags()
+
+	parseErr := parseFlags(os.Args)
+	if parseErr == pflag.ErrHelp {
+		return
 	}
 
-	viper.SetEnvPrefix("codeship")
+	ctx := context.Background()
+	// trap Ctrl+C, SIGTERM and SIGHUP and call cancel on the context
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
 
-	// CODESHIP_USERNAME
-	err = viper.BindEnv("username")
-	if err != nil {
-		log.Fatal(err)
+	sd := handleSignals(cancel, shutdownTimeout)
+
+	start := time.Now()
+	m, err := run(ctx, parseErr)
+	sd.stop()
+
+	// an interrupted run must never look like it succeeded
+	if ctx.Err() != nil {
+		err = interruptedError{signal: sd.received()}
 	}
 
-	// CODESHIP_PASSWORD
-	err = viper.BindEnv("password")
-	if err != nil {
-		log.Fatal(err)
+	code := exitCode(err)
+	res := m.report.result(err, code)
+
+	metrics.observeOutcome(outcome(err), time.Since(start))
+	if path := viper.GetString("metrics-textfile"); path != "" {
+		if err := writeMetricsFile(path); err != nil {
+			logError(eventError, fields{"path": path}, "Writing metrics to %s failed: %v", path, err)
+		}
 	}
 
-	// CODESHIP_ORGANIZATION
-	err = viper.BindEnv("organization")
-	if err != nil {
-		log.Fatal(err)
+	if path := viper.GetString("result-file"); path != "" {
+		if err := writeResultFile(path, res); err != nil {
+			logError(eventError, fields{"path": path}, "Writing result to %s failed: %v", path, err)
+		}
 	}
 
-	// CI_PROJECT_ID
-	err = viper.BindEnv("project_id", "CI_PROJECT_ID")
-	if err != nil {
-		log.Fatal(err)
+	if _, ok := err.(interruptedError); ok {
+		runCleanup(cleanupCommands(), shutdownTimeout(), res, sd.received())
+	}
+
+	f := fields{
+		"exit_code":       code,
+		"decision":        res.Decision,
+		"elapsed_seconds": elapsedSeconds(start),
+	}
+	if sig := sd.received(); sig != nil {
+		f["signal"] = sig.String()
 	}
+	logInfo(eventExit, f, "Exiting with code %d", code)
 
-	// CI_BUILD_ID
-	err = viper.BindEnv("build_id", "CI_BUILD_ID")
+	if code != 0 {
+		cancel()
+		os.Exit(code)
+	}
+}
+
+// shutdownTimeout returns how long an interrupted run gets to wind down,
+// and its cleanup commands get to run.
+func shutdownTimeout() time.Duration {
+	if d := viper.GetDuration("shutdown-timeout"); d > 0 {
+		return d
+	}
+	return defaultShutdownTimeout
+}
+
+// run reads the configuration and runs the command. parseErr is the error
+// parsing the command line failed with, if any. It returns the monitor it
+// used, which is the zero monitor if the configuration is invalid.
+func run(ctx context.Context, parseErr error) (monitor, error) {
+	err := viper.BindPFlags(pflag.CommandLine)
 	if err != nil {
-		log.Fatal(err)
+		return monitor{}, configError(err.Error())
+	}
+
+	viper.SetEnvPrefix("codeship")
+	viper.SetEnvKeyReplacer(strings.NewReplacer("-", "_"))
+
+	if err := bindEnv(); err != nil {
+		return monitor{}, configError(err.Error())
+	}
+	if err := configureLogger(); err != nil {
+		return monitor{}, err
+	}
+	if parseErr != nil {
+		return monitor{}, configError(parseErr.Error())
 	}
 
 	user := viper.GetString("username")
 	if user == "" {
-		log.Fatal("CODESHIP_USERNAME required")
+		return monitor{}, configError("CODESHIP_USERNAME required")
 	}
 
 	password := viper.GetString("password")
 	if password == "" {
-		log.Fatal("CODESHIP_PASSWORD required")
+		return monitor{}, configError("CODESHIP_PASSWORD required")
 	}
 
 	orgName := viper.GetString("organization")
 	if orgName == "" {
-		log.Fatal("CODESHIP_ORGANIZATION required")
-	}
-
-	projectUUID := viper.GetString("project_id")
-	if projectUUID == "" {
-		log.Fatal("CI_PROJECT_ID required")
+		return monitor{}, configError("CODESHIP_ORGANIZATION required")
 	}
 
-	buildUUID := viper.GetString("build_id")
-	if buildUUID == "" {
-		log.Fatal("CI_BUILD_ID required")
+	command := pflag.Arg(0)
+	switch command {
+	case "", commandWaitFor, commandGate, commandStatus, commandWatch:
+	default:
+		return monitor{}, configErrorf("unknown command %q", command)
 	}
 
-	ctx := context.Background()
-	// trap Ctrl+C and call cancel on the context
-	ctx, cancel := context.WithCancel(ctx)
-	c := make(chan os.Signal, 1)
-
-	signal.Notify(c, os.Interrupt)
-	defer func() {
-		signal.Stop(c)
-		close(c)
-		cancel()
-	}()
-
-	go func() {
-		<-c
-		cancel()
-	}()
-
 	auth := codeship.NewBasicAuth(user, password)
 	client, err := codeship.New(auth)
 	if err != nil {
-		log.Fatal(err)
+		return monitor{}, configError(err.Error())
 	}
 
 	org, err := client.Organization(ctx, orgName)
 	if err != nil {
-		log.Fatal(err)
+		return monitor{}, apiError{op: "get organization " + orgName, err: err}
 	}
 
-	build, _, err := org.GetBuild(ctx, projectUUID, buildUUID)
+	m, err := newMonitor(org)
 	if err != nil {
-		log.Fatal(err)
+		return monitor{}, err
+	}
+
+	if addr := viper.GetString("metrics-addr"); addr != "" {
+		serveMetrics(ctx, addr)
+	}
+
+	switch command {
+	case commandWaitFor:
+		err = runWaitFor(ctx, m)
+	case commandGate:
+		err = runGate(ctx, m)
+	case commandStatus:
+		err = runStatus(ctx, m)
+	case commandWatch:
+		err = runWatch(ctx, m)
+	default:
+		err = runWait(ctx, m)
+	}
+	return m, err
+}
+
+// exitCode logs err and returns the exit code for it.
+func exitCode(err error) int {
+	switch err.(type) {
+	case nil:
+		return 0
+	case configError:
+		logError(eventError, nil, "%v", err)
+		return exitConfig
+	case interruptedError:
+		var f fields
+		if sig := err.(interruptedError).signal; sig != nil {
+			f = fields{"signal": sig.String()}
+		}
+		logError(eventInterrupted, f, "%v", err)
+		return exitInterrupted
+	case timeoutError:
+		logError(eventTimeout, nil, "%v", err)
+		return exitTimeout
+	case supersededError:
+		logError(eventSuperseded, nil, "%v", err)
+		return exitSuperseded
+	case previousFailedError:
+		logError(eventPredecessorFailed, nil, "%v", err)
+		return exitPreviousFailed
+	case buildFailedError:
+		logError(eventBuildFinished, nil, "%v", err)
+		return exitFailed
+	}
+
+	if _, ok := errors.Cause(err).(codeship.ErrUnauthorized); ok {
+		logError(eventError, nil, "%v", err)
+		return exitAuth
+	}
+	if _, ok := err.(apiError); ok {
+		logError(eventAPIError, nil, "%v", err)
+		return exitAPI
+	}
+
+	logError(eventError, nil, "%v", err)
+	return exitFailed
+}
+
+// runWait waits on the builds ahead of ours, or supersedes them.
+func runWait(ctx context.Context, m monitor) error {
+	projectUUID := viper.GetString("project_id")
+	if projectUUID == "" {
+		return configError("CI_PROJECT_ID required")
 	}
 
-	m := monitor{
-		buildGetter: org,
+	buildUUID := viper.GetString("build_id")
+	if buildUUID == "" {
+		return configError("CI_BUILD_ID required")
 	}
 
-	err = m.waitOnPreviousBuilds(ctx, projectUUID, buildUUID, build.Branch)
+	build, err := m.getBuild(ctx, projectUUID, buildUUID)
 	if err != nil {
-		log.Fatal(err)
+		return err
 	}
+	m.report.setBuild(build)
+
+	m = m.withPolicy(build.Branch)
+
+	supersede := m.supersede
+	if supersede && branchProtected(build.Branch, viper.GetStringSlice("supersede-protected")) {
+		logInfo(eventWarning, fields{"build_uuid": buildUUID, "branch": build.Branch}, "Branch %s is protected from --supersede, waiting on previous builds instead", build.Branch)
+		supersede = false
+	}
+
+	if supersede {
+		return m.supersedePreviousBuilds(ctx, projectUUID, buildUUID, build.Branch)
+	}
+	return m.waitOnPreviousBuilds(ctx, projectUUID, buildUUID, build.Branch)
 }
 
 type buildGetter interface {
@@ -143,79 +349,396 @@ type buildGetter interface {
 	GetBuild(context.Context, string, string) (codeship.Build, codeship.Response, error)
 }
 
+type buildStopper interface {
+	StopBuild(ctx context.Context, projectUUID, buildUUID string) (bool, codeship.Response, error)
+}
+
 type monitor struct {
 	buildGetter
+	buildStopper
+	buildCreator
+	buildRestarter
+	stepLister
+	pipelineLister
+	projectGetter
+
+	// maxWait bounds the time spent waiting on the whole queue, 0 waits forever
+	maxWait   time.Duration
+	onTimeout timeoutPolicy
+
+	// poll controls how often a running build is checked
+	poll backoff
+
+	// maxRetries bounds how often a rate limited or failed API call is
+	// retried, waiting retryBackoff between attempts
+	maxRetries   int
+	retryBackoff backoff
+
+	// dryRun logs builds that would be stopped instead of stopping them
+	dryRun bool
+
+	// supersede stops the builds ahead of ours instead of waiting on them
+	supersede bool
+
+	// onNewer decides what happens to our build when a newer one appears on
+	// the branch while we wait
+	onNewer newerBuildPolicy
+
+	// requireSuccess fails the wait when a build ahead of ours fails,
+	// ignoreStopped excludes builds that were only stopped
+	requireSuccess bool
+	ignoreStopped  bool
+
+	// unknownState is the state assumed for statuses the waiter doesn't know
+	unknownState buildState
+
+	// order decides which builds are ahead of ours, isAncestor is used to
+	// compare commits when ordering by ancestry
+	order      buildOrder
+	isAncestor func(ancestor, descendant string) bool
+
+	// maxConcurrent is how many builds on the branch may run past the gate
+	// at once
+	maxConcurrent int
+
+	// lock names a queue shared with the builds of lockProjects
+	lock         string
+	lockProjects []string
+
+	// branchGroups lets builds on different branches share a queue
+	branchGroups []branchGroup
+
+	// superseded are the builds we stopped with --supersede, which are left
+	// out of the queue while we wait on the builds we didn't stop
+	superseded []string
+
+	// steps and pipeline, when set, are the steps of a build ahead of ours
+	// on Pro projects and the type of pipeline on Basic projects we wait on
+	// instead of the whole build. projectTypes caches which is which.
+	steps        []string
+	pipeline     string
+	projectTypes map[string]codeship.ProjectType
+
+	// report records the builds we waited on for --result-file
+	report *waitReport
+
+	// config holds the per-branch policies of the config file, if any
+	config *configFile
 }
 
 func (m monitor) waitOnPreviousBuilds(ctx context.Context, projectUUID, buildUUID, branch string) error {
-	// Find a list all builds running for the branch
-	watching, err := m.buildsToWatch(ctx, projectUUID, branch)
-	if err != nil {
-		return err
+	// The timeout applies across the whole queue rather than per build
+	var timeout <-chan time.Time
+	if m.maxWait > 0 {
+		timer := time.NewTimer(m.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
 	}
 
-	// Sort builds by oldest allocated time
-	sort.Sort(allocatedAtSort(watching))
+	var (
+		start     = time.Now()
+		poll      = m.poll
+		blocking  string
+		waitingOn = make(map[string]codeship.Build)
+		passed    = make(map[string]time.Time)
+	)
 
-	// Loop through list of builds on branch.
-	// Check every 30 seconds to see if build has completed
-	// exit out of loop when we reach out build
-	ticker := time.NewTicker(30 * time.Second)
-	for _, b := range watching {
-		if b.UUID == buildUUID {
-			// It is our turn to run --exit
-			log.Println("Resuming build")
-			break
+	// Recompute the queue on every poll so restarted builds and builds that
+	// were queued before ours but allocated later are picked up. Resume once
+	// no build ahead of ours is still running.
+	for {
+		q, err := m.queue(ctx, projectUUID, buildUUID, branch)
+		if err != nil {
+			return err
+		}
+
+		q.ahead, err = m.stillAhead(ctx, q.ahead, m.slots(), waitingOn, passed)
+		if err != nil {
+			return err
+		}
+		m.report.waitingOn(q.ahead)
+
+		f := fields{
+			"build_uuid":      buildUUID,
+			"branch":          branch,
+			"position":        q.position(),
+			"queue_depth":     len(q.ahead) + 1 + len(q.behind),
+			"ahead":           uuids(q.ahead),
+			"elapsed_seconds": elapsedSeconds(start),
+		}
+		if m.lock != "" {
+			f["lock"] = m.lock
+		}
+
+		metrics.setQueueDepth(f["queue_depth"].(int))
+		logDebug(eventQueueComputed, f, "Build %s is at position %d of %d in the queue for branch %s", buildUUID, q.position(), f["queue_depth"], branch)
+
+		if len(q.ahead) < m.slots() {
+			// It is our turn to run
+			f["slot"] = q.position()
+			if m.slots() > 1 {
+				logInfo(eventResumed, f, "Resuming build in slot %d of %d", q.position(), m.slots())
+			} else {
+				logInfo(eventResumed, f, "Resuming build")
+			}
+			return nil
+		}
+
+		if m.onNewer != newerIgnore {
+			if err := m.checkSuperseded(ctx, q); err != nil {
+				return err
+			}
+		}
+
+		// a new build is ahead of us, start polling it from the base interval
+		if q.ahead[0].UUID != blocking {
+			blocking = q.ahead[0].UUID
+			poll.reset()
+		}
+
+		f["blocking_uuid"] = blocking
+		if m.lock != "" {
+			logInfo(eventWaiting, f, "Waiting on build %s, position %d in queue for lock %s", blocking, q.position(), m.lock)
 		} else {
-			// wait for the build ahead of us to finish
-			finished, err := m.buildFinished(ctx, b)
+			logInfo(eventWaiting, f, "Waiting on build %s, position %d in queue", blocking, q.position())
+		}
+
+		select {
+		case <-ctx.Done():
+			return interruptedError{} // user has hit ctrl+c
+		case <-timeout:
+			return m.handleTimeout(ctx, projectUUID, q.ahead, start)
+		case <-time.After(poll.next()):
+		}
+	}
+}
+
+// stillAhead returns the builds that are still running ahead of ours.
+//
+// The build list can lag behind, so listed builds are checked directly,
+// oldest first, until confirm of them are found to be still running. Builds
+// we waited on that have since left the list are checked as well so we
+// notice how they finished. waitingOn and passed carry this state between
+// polls.
+//
+// Only builds that passed the step or pipeline gate while still running are
+// remembered in passed, keyed by when they were allocated. A build listed as
+// running again after it finished was restarted and holds us back again.
+func (m monitor) stillAhead(ctx context.Context, listed []codeship.Build, confirm int, waitingOn map[string]codeship.Build, passed map[string]time.Time) ([]codeship.Build, error) {
+	var ahead []codeship.Build
+
+	for uuid, b := range waitingOn {
+		if indexOf(listed, uuid) >= 0 {
+			continue
+		}
+		done, err := m.passedGate(ctx, b)
+		if err != nil {
+			return nil, err
+		}
+		if done {
+			delete(waitingOn, uuid)
+			m.rememberPassed(passed, b)
+			m.report.passed(uuid)
+			logPredecessorFinished(b)
+			continue
+		}
+		// still running even though it is no longer listed
+		ahead = append(ahead, b)
+	}
+
+	running := len(ahead)
+	unlisted := running > 0
+	for _, b := range listed {
+		if allocatedAt, ok := passed[b.UUID]; ok && allocatedAt.Equal(b.AllocatedAt) {
+			continue
+		}
+		if running < confirm {
+			done, err := m.passedGate(ctx, b)
 			if err != nil {
-				return err
+				return nil, err
 			}
-			if finished {
+			if done {
+				if _, ok := waitingOn[b.UUID]; ok {
+					delete(waitingOn, b.UUID)
+					m.report.passed(b.UUID)
+					logPredecessorFinished(b)
+				}
+				m.rememberPassed(passed, b)
+				continue
+			}
+			running++
+		}
+		ahead = append(ahead, b)
+	}
+
+	// listed builds are sorted already, only the unlisted ones need placing
+	if unlisted {
+		m.sortBuilds(ahead)
+	}
+
+	for _, b := range ahead {
+		waitingOn[b.UUID] = b
+	}
+
+	return ahead, nil
+}
+
+// rememberPassed records that b passed the gate when we gate on steps or a
+// pipeline, so it isn't checked again while it keeps running.
+func (m monitor) rememberPassed(passed map[string]time.Time, b codeship.Build) {
+	if len(m.steps) > 0 || m.pipeline != "" {
+		passed[b.UUID] = b.AllocatedAt
+	}
+}
+
+// logPredecessorFinished logs that b, which we were waiting on, has passed
+// the gate.
+func logPredecessorFinished(b codeship.Build) {
+	logInfo(eventPredecessorFinished, fields{"build_uuid": b.UUID, "commit_sha": b.CommitSha, "branch": b.Branch}, "Build %s ahead of ours finished", b.UUID)
+}
+
+// slots returns how many builds on the branch may run at once.
+func (m monitor) slots() int {
+	if m.maxConcurrent < 1 {
+		return 1
+	}
+	return m.maxConcurrent
+}
+
+// handleTimeout applies the timeout policy once the maximum wait has been
+// exceeded. remaining are the builds still ahead of ours, starting with the
+// one that was blocking us, and projectUUID is our project. start is when we
+// started waiting.
+func (m monitor) handleTimeout(ctx context.Context, projectUUID string, remaining []codeship.Build, start time.Time) error {
+	blocking := remaining[0]
+	f := fields{
+		"blocking_uuid":   blocking.UUID,
+		"ahead":           uuids(remaining),
+		"max_wait":        m.maxWait.String(),
+		"on_timeout":      string(m.onTimeout),
+		"elapsed_seconds": elapsedSeconds(start),
+	}
+	logWarn(eventTimeout, f, "Exceeded max wait of %s waiting on build %s", m.maxWait, blocking.UUID)
+	m.report.timeout()
+
+	switch m.onTimeout {
+	case timeoutProceed:
+		logInfo(eventResumed, f, "Resuming build")
+		return nil
+	case timeoutStop:
+		// stop just the blocking builds, oldest first, so that a slot frees up
+		blocked := len(remaining) - m.slots() + 1
+		for _, b := range remaining {
+			if blocked <= 0 {
+				break
+			}
+			// never stop builds of other projects sharing a lock with us
+			if b.ProjectUUID != projectUUID {
 				continue
-			} else {
-				log.Println("Waiting on build", b.UUID)
 			}
-		BuildWait:
-			for {
-				select {
-				case <-ctx.Done():
-					return nil // user has hit ctrl+c
-				case <-ticker.C:
-					finished, err := m.buildFinished(ctx, b)
-					if err != nil {
-						return err
-					}
-					if finished {
-						break BuildWait
-					} else {
-						log.Println("Waiting on build", b.UUID)
-					}
+			finished, err := m.buildFinished(ctx, b)
+			if err != nil {
+				return err
+			}
+			if !finished {
+				if err := m.stopBuild(ctx, b, "max wait exceeded"); err != nil {
+					return err
 				}
 			}
+			blocked--
 		}
+		logInfo(eventResumed, f, "Resuming build")
+		return nil
+	default:
+		return timeoutError{maxWait: m.maxWait, build: blocking}
+	}
+}
+
+func (m monitor) getBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, error) {
+	var build codeship.Build
+	err := m.retry(ctx, "get build "+buildUUID, func() (codeship.Response, error) {
+		var (
+			resp codeship.Response
+			err  error
+		)
+		build, resp, err = m.GetBuild(ctx, projectUUID, buildUUID)
+		return resp, err
+	})
+	return build, err
+}
+
+func (m monitor) listBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	var (
+		builds codeship.BuildList
+		resp   codeship.Response
+	)
+	err := m.retry(ctx, "list builds", func() (codeship.Response, error) {
+		var err error
+		builds, resp, err = m.ListBuilds(ctx, projectUUID, opts...)
+		return resp, err
+	})
+	return builds, resp, err
+}
+
+// stopBuild stops b, logging reason. In dry run mode it only logs.
+func (m monitor) stopBuild(ctx context.Context, b codeship.Build, reason string) error {
+	if m.dryRun {
+		logInfo(eventBuildStopped, fields{"build_uuid": b.UUID, "reason": reason, "dry_run": true}, "Dry run, not stopping build %s (%s)", b.UUID, reason)
+		return nil
 	}
+
+	err := m.retry(ctx, "stop build "+b.UUID, func() (codeship.Response, error) {
+		_, resp, err := m.StopBuild(ctx, b.ProjectUUID, b.UUID)
+		return resp, err
+	})
+	if err != nil {
+		return err
+	}
+
+	m.report.stopped(b)
+	logInfo(eventBuildStopped, fields{"build_uuid": b.UUID, "reason": reason}, "Stopped build %s (%s)", b.UUID, reason)
 	return nil
 }
 
 func (m monitor) buildFinished(ctx context.Context, b codeship.Build) (bool, error) {
-	build, _, err := m.GetBuild(ctx, b.ProjectUUID, b.UUID)
+	build, err := m.getBuild(ctx, b.ProjectUUID, b.UUID)
 	if err != nil {
 		return false, err
 	}
+	m.report.update(build)
+
+	// a build is considered finished once it is no longer queued or running
+	if m.state(build).active() {
+		return false, nil
+	}
 
-	// a build is considered finished if it is not testing
-	return (build.Status != "testing"), nil
+	if m.requireSuccess && m.buildFailed(build) {
+		return true, previousFailedError{build: build}
+	}
+
+	return true, nil
+}
+
+// buildFailed reports whether a finished build counts as a failure.
+func (m monitor) buildFailed(b codeship.Build) bool {
+	switch m.state(b) {
+	case stateFailed:
+		return true
+	case stateCancelled:
+		return !m.ignoreStopped
+	}
+	return false
 }
 
+// buildsToWatch returns the running builds of a project on branch, or on any
+// branch if branch is empty.
 func (m monitor) buildsToWatch(ctx context.Context, projectUUID, branch string) ([]codeship.Build, error) {
 	var (
 		pageWithRunningBuild bool
 		watching             []codeship.Build
 	)
 
-	builds, resp, err := m.ListBuilds(ctx, projectUUID)
+	builds, resp, err := m.listBuilds(ctx, projectUUID)
 	if err != nil {
 		return nil, err
 	}
@@ -224,9 +747,9 @@ func (m monitor) buildsToWatch(ctx context.Context, projectUUID, branch string)
 	for {
 		pageWithRunningBuild = false
 		for _, b := range builds.Builds {
-			if b.Status == "testing" {
+			if m.state(b).active() {
 				pageWithRunningBuild = true
-				if b.Branch == branch {
+				if branch == "" || m.sameBranchGroup(branch, b.Branch) {
 					watching = append(watching, b)
 				}
 			}
@@ -242,7 +765,7 @@ func (m monitor) buildsToWatch(ctx context.Context, projectUUID, branch string)
 
 		next, _ := resp.NextPage()
 
-		builds, resp, err = m.ListBuilds(ctx, projectUUID, codeship.Page(next), codeship.PerPage(50))
+		builds, resp, err = m.listBuilds(ctx, projectUUID, codeship.Page(next), codeship.PerPage(50))
 		if err != nil {
 			return nil, err
 		}
@@ -7,6 +7,7 @@ import (
 	"time"
 
 	codeship "github.com/codeship/codeship-go"
+	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/require"
 )
@@ -32,10 +33,10 @@ type mockBuildGetter struct {
 
 func (m mockBuildGetter) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
 	now := time.Now()
-	build1 := codeship.Build{UUID: "2", Status: "testing", Branch: "test-branch", AllocatedAt: now.Add(time.Duration(-1) * time.Minute)}
-	build2 := codeship.Build{UUID: "3", Status: "success", Branch: "test-branch", AllocatedAt: now}
-	build3 := codeship.Build{UUID: "1", Status: "testing", Branch: "test-branch", AllocatedAt: now.Add(time.Duration(-5) * time.Minute)}
-	build4 := codeship.Build{UUID: "4", Status: "testing", Branch: "another-branch"}
+	build1 := codeship.Build{UUID: "2", ProjectUUID: projectUUID, Status: "testing", Branch: "test-branch", AllocatedAt: now.Add(time.Duration(-1) * time.Minute)}
+	build2 := codeship.Build{UUID: "3", ProjectUUID: projectUUID, Status: "success", Branch: "test-branch", AllocatedAt: now}
+	build3 := codeship.Build{UUID: "1", ProjectUUID: projectUUID, Status: "testing", Branch: "test-branch", AllocatedAt: now.Add(time.Duration(-5) * time.Minute)}
+	build4 := codeship.Build{UUID: "4", ProjectUUID: projectUUID, Status: "testing", Branch: "another-branch"}
 
 	return codeship.BuildList{
 		Builds: []codeship.Build{build1, build2, build3, build4},
@@ -44,8 +45,10 @@ func (m mockBuildGetter) ListBuilds(ctx context.Context, projectUUID string, opt
 
 func (m mockBuildGetter) GetBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, codeship.Response, error) {
 	return codeship.Build{
-		Branch: "test-branch",
-		Status: m.buildStatus,
+		UUID:        buildUUID,
+		ProjectUUID: projectUUID,
+		Branch:      "test-branch",
+		Status:      m.buildStatus,
 	}, codeship.Response{}, nil
 }
 
@@ -60,9 +63,12 @@ func TestWaitOnPreviousBuilds(t *testing.T) {
 
 func TestBuildFinished(t *testing.T) {
 	testCases := []struct {
-		name        string
-		buildStatus string
-		finished    bool
+		name           string
+		buildStatus    string
+		requireSuccess bool
+		ignoreStopped  bool
+		finished       bool
+		failed         bool
 	}{
 		{
 			name:        "success status",
@@ -72,6 +78,33 @@ func TestBuildFinished(t *testing.T) {
 			name:        "testing status",
 			buildStatus: "testing",
 			finished:    false,
+		}, {
+			name:        "error status",
+			buildStatus: "error",
+			finished:    true,
+		}, {
+			name:           "error status requiring success",
+			buildStatus:    "error",
+			requireSuccess: true,
+			finished:       true,
+			failed:         true,
+		}, {
+			name:           "stopped status requiring success",
+			buildStatus:    "stopped",
+			requireSuccess: true,
+			finished:       true,
+			failed:         true,
+		}, {
+			name:           "stopped status ignoring stopped",
+			buildStatus:    "stopped",
+			requireSuccess: true,
+			ignoreStopped:  true,
+			finished:       true,
+		}, {
+			name:           "success status requiring success",
+			buildStatus:    "success",
+			requireSuccess: true,
+			finished:       true,
 		},
 	}
 
@@ -86,10 +119,17 @@ func TestBuildFinished(t *testing.T) {
 				buildGetter: mockBuildGetter{
 					buildStatus: tc.buildStatus,
 				},
+				requireSuccess: tc.requireSuccess,
+				ignoreStopped:  tc.ignoreStopped,
 			}
 
 			finished, err := monitor.buildFinished(context.TODO(), b)
-			require.NoError(t, err)
+			if tc.failed {
+				_, ok := err.(previousFailedError)
+				require.True(t, ok)
+			} else {
+				require.NoError(t, err)
+			}
 			assert.Equal(t, finished, tc.finished)
 		})
 	}
@@ -104,3 +144,210 @@ func TestBuildsToWatch(t *testing.T) {
 	require.NoError(t, err)
 	assert.Equal(t, len(builds), 2)
 }
+
+type mockBuildStopper struct {
+	stopped []string
+}
+
+func (m *mockBuildStopper) StopBuild(ctx context.Context, projectUUID, buildUUID string) (bool, codeship.Response, error) {
+	m.stopped = append(m.stopped, buildUUID)
+	return true, codeship.Response{}, nil
+}
+
+func TestWaitOnPreviousBuildsTimeout(t *testing.T) {
+	testCases := []struct {
+		name          string
+		onTimeout     timeoutPolicy
+		maxConcurrent int
+		err           bool
+		stopped       []string
+	}{
+		{
+			name:      "fail policy",
+			onTimeout: timeoutFail,
+			err:       true,
+		}, {
+			name:      "proceed policy",
+			onTimeout: timeoutProceed,
+		}, {
+			name:      "stop policy",
+			onTimeout: timeoutStop,
+			stopped:   []string{"1", "2"},
+		}, {
+			name:          "stop policy with concurrent builds",
+			onTimeout:     timeoutStop,
+			maxConcurrent: 2,
+			stopped:       []string{"1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stopper := &mockBuildStopper{}
+			monitor := &monitor{
+				buildGetter: mockBuildGetter{
+					buildStatus: "testing",
+				},
+				buildStopper:  stopper,
+				maxWait:       10 * time.Millisecond,
+				onTimeout:     tc.onTimeout,
+				maxConcurrent: tc.maxConcurrent,
+			}
+
+			err := monitor.waitOnPreviousBuilds(context.TODO(), "project-uuid", "build-uuid", "test-branch")
+			if tc.err {
+				require.Error(t, err)
+				timeoutErr, ok := err.(timeoutError)
+				require.True(t, ok)
+				assert.Equal(t, "1", timeoutErr.build.UUID)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.stopped, stopper.stopped)
+		})
+	}
+}
+
+func TestWaitOnPreviousBuildsTimeoutLock(t *testing.T) {
+	now := time.Now()
+	builds := mockProjectBuilds{
+		"api": {
+			{UUID: "api-1", ProjectUUID: "api", Status: "testing", Branch: "master", QueuedAt: now.Add(-time.Minute)},
+			{UUID: "self", ProjectUUID: "api", Status: "testing", Branch: "master", QueuedAt: now},
+		},
+		"web": {
+			{UUID: "web-1", ProjectUUID: "web", Status: "testing", Branch: "master", QueuedAt: now.Add(-2 * time.Minute)},
+		},
+	}
+
+	stopper := &mockBuildStopper{}
+	monitor := &monitor{
+		buildGetter:  builds,
+		buildStopper: stopper,
+		order:        orderQueued,
+		lock:         "staging",
+		lockProjects: []string{"api", "web"},
+		maxWait:      10 * time.Millisecond,
+		onTimeout:    timeoutStop,
+	}
+
+	err := monitor.waitOnPreviousBuilds(context.TODO(), "api", "self", "master")
+	require.NoError(t, err)
+	assert.Equal(t, []string{"api-1"}, stopper.stopped)
+}
+
+// mockBuildList serves a fixed list of builds and returns them by UUID.
+// Unlisted builds are only returned by GetBuild.
+type mockBuildList struct {
+	builds   []codeship.Build
+	unlisted []codeship.Build
+}
+
+func (m mockBuildList) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	return codeship.BuildList{
+		Builds: m.builds,
+	}, codeship.Response{}, nil
+}
+
+func (m mockBuildList) GetBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, codeship.Response, error) {
+	for _, b := range append(m.builds, m.unlisted...) {
+		if b.UUID == buildUUID {
+			return b, codeship.Response{}, nil
+		}
+	}
+	return codeship.Build{}, codeship.Response{}, codeship.ErrNotFound{}
+}
+
+func TestWaitOnPreviousBuildsMaxConcurrent(t *testing.T) {
+	now := time.Now()
+	builds := mockBuildList{
+		builds: []codeship.Build{
+			{UUID: "1", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-3 * time.Minute)},
+			{UUID: "2", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-2 * time.Minute)},
+			{UUID: "3", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)},
+			{UUID: "self", Status: "testing", Branch: "test-branch", QueuedAt: now},
+		},
+	}
+
+	testCases := []struct {
+		name          string
+		maxConcurrent int
+		timeout       bool
+	}{
+		{
+			name:          "free slot",
+			maxConcurrent: 4,
+		}, {
+			name:          "all slots taken",
+			maxConcurrent: 3,
+			timeout:       true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				buildGetter:   builds,
+				order:         orderQueued,
+				maxWait:       10 * time.Millisecond,
+				onTimeout:     timeoutFail,
+				maxConcurrent: tc.maxConcurrent,
+			}
+
+			err := monitor.waitOnPreviousBuilds(context.TODO(), "project-uuid", "self", "test-branch")
+			if tc.timeout {
+				_, ok := err.(timeoutError)
+				require.True(t, ok)
+			} else {
+				require.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestWaitOnPreviousBuildsInterrupted(t *testing.T) {
+	now := time.Now()
+	builds := mockBuildList{
+		builds: []codeship.Build{
+			{UUID: "1", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)},
+			{UUID: "self", Status: "testing", Branch: "test-branch", QueuedAt: now},
+		},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	monitor := &monitor{
+		buildGetter: builds,
+		order:       orderQueued,
+	}
+
+	err := monitor.waitOnPreviousBuilds(ctx, "project-uuid", "self", "test-branch")
+	_, ok := err.(interruptedError)
+	require.True(t, ok)
+}
+
+func TestExitCode(t *testing.T) {
+	testCases := []struct {
+		name string
+		err  error
+		code int
+	}{
+		{"success", nil, 0},
+		{"config", configError("CI_BUILD_ID required"), exitConfig},
+		{"auth", apiError{op: "get organization", err: errors.Wrap(codeship.ErrUnauthorized("invalid credentials"), "authentication failed")}, exitAuth},
+		{"api", apiError{op: "get build", err: codeship.ErrRateLimitExceeded}, exitAPI},
+		{"timeout", timeoutError{}, exitTimeout},
+		{"predecessor failed", previousFailedError{}, exitPreviousFailed},
+		{"superseded", supersededError{}, exitSuperseded},
+		{"interrupted", interruptedError{}, exitInterrupted},
+		{"build failed", buildFailedError{}, exitFailed},
+		{"other", errors.New("boom"), exitFailed},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.code, exitCode(tc.err))
+		})
+	}
+}
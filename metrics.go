@@ -0,0 +1,309 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/pkg/errors"
+)
+
+// Buckets of the histograms, in seconds.
+var (
+	apiLatencyBuckets   = []float64{0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10}
+	waitDurationBuckets = []float64{10, 30, 60, 120, 300, 600, 1200, 1800, 3600, 7200}
+)
+
+// histogram counts observations into cumulative buckets like a Prometheus
+// histogram.
+type histogram struct {
+	buckets []float64
+	counts  []uint64
+	sum     float64
+	count   uint64
+}
+
+func newHistogram(buckets []float64) *histogram {
+	return &histogram{buckets: buckets, counts: make([]uint64, len(buckets))}
+}
+
+func (h *histogram) observe(v float64) {
+	for i, upper := range h.buckets {
+		if v <= upper {
+			h.counts[i]++
+		}
+	}
+	h.sum += v
+	h.count++
+}
+
+// metricsRegistry collects the metrics of a run and writes them in the
+// Prometheus text format.
+type metricsRegistry struct {
+	mu sync.Mutex
+
+	apiCalls     map[[2]string]uint64 // by method and result
+	apiLatency   map[string]*histogram
+	retries      uint64
+	queueDepth   int
+	outcomes     map[string]uint64
+	waitDuration map[string]*histogram // by outcome
+}
+
+func newMetricsRegistry() *metricsRegistry {
+	return &metricsRegistry{
+		apiCalls:     make(map[[2]string]uint64),
+		apiLatency:   make(map[string]*histogram),
+		outcomes:     make(map[string]uint64),
+		waitDuration: make(map[string]*histogram),
+	}
+}
+
+var metrics = newMetricsRegistry()
+
+// observeAPICall records a call of an Organization method.
+func (r *metricsRegistry) observeAPICall(method string, d time.Duration, err error) {
+	result := "ok"
+	switch {
+	case err == nil:
+	case errors.Cause(err) == codeship.ErrRateLimitExceeded:
+		result = "rate_limited"
+	default:
+		result = "error"
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.apiCalls[[2]string{method, result}]++
+	h, ok := r.apiLatency[method]
+	if !ok {
+		h = newHistogram(apiLatencyBuckets)
+		r.apiLatency[method] = h
+	}
+	h.observe(d.Seconds())
+}
+
+func (r *metricsRegistry) observeRetry() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.retries++
+}
+
+func (r *metricsRegistry) setQueueDepth(depth int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.queueDepth = depth
+}
+
+// observeOutcome records how a run ended and how long it took.
+func (r *metricsRegistry) observeOutcome(outcome string, d time.Duration) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.outcomes[outcome]++
+	h, ok := r.waitDuration[outcome]
+	if !ok {
+		h = newHistogram(waitDurationBuckets)
+		r.waitDuration[outcome] = h
+	}
+	h.observe(d.Seconds())
+}
+
+// outcome names how a run that returned err ended.
+func outcome(err error) string {
+	switch err.(type) {
+	case nil:
+		return "success"
+	case timeoutError:
+		return "timeout"
+	case supersededError:
+		return "superseded"
+	case previousFailedError:
+		return "predecessor_failed"
+	case buildFailedError:
+		return "build_failed"
+	case interruptedError:
+		return "interrupted"
+	}
+	return "error"
+}
+
+// writeTo writes every metric in the Prometheus text exposition format.
+func (r *metricsRegistry) writeTo(w io.Writer) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	var b strings.Builder
+
+	writeHeader(&b, "build_waiter_wait_duration_seconds", "Time spent waiting, by outcome.", "histogram")
+	for _, outcome := range histogramKeys(r.waitDuration) {
+		writeHistogram(&b, "build_waiter_wait_duration_seconds", fmt.Sprintf("outcome=%q", outcome), r.waitDuration[outcome])
+	}
+
+	writeHeader(&b, "build_waiter_outcomes_total", "Runs by outcome.", "counter")
+	for _, outcome := range counterKeys(r.outcomes) {
+		fmt.Fprintf(&b, "build_waiter_outcomes_total{outcome=%q} %d\n", outcome, r.outcomes[outcome])
+	}
+
+	writeHeader(&b, "build_waiter_queue_depth", "Builds in the queue at the last poll, including ours.", "gauge")
+	fmt.Fprintf(&b, "build_waiter_queue_depth %d\n", r.queueDepth)
+
+	writeHeader(&b, "build_waiter_api_calls_total", "Codeship API calls, by method and result.", "counter")
+	calls := make([][2]string, 0, len(r.apiCalls))
+	for k := range r.apiCalls {
+		calls = append(calls, k)
+	}
+	sort.Slice(calls, func(i, j int) bool {
+		if calls[i][0] != calls[j][0] {
+			return calls[i][0] < calls[j][0]
+		}
+		return calls[i][1] < calls[j][1]
+	})
+	for _, k := range calls {
+		fmt.Fprintf(&b, "build_waiter_api_calls_total{method=%q,result=%q} %d\n", k[0], k[1], r.apiCalls[k])
+	}
+
+	writeHeader(&b, "build_waiter_api_call_duration_seconds", "Latency of Codeship API calls, by method.", "histogram")
+	for _, method := range histogramKeys(r.apiLatency) {
+		writeHistogram(&b, "build_waiter_api_call_duration_seconds", fmt.Sprintf("method=%q", method), r.apiLatency[method])
+	}
+
+	writeHeader(&b, "build_waiter_api_retries_total", "Retried Codeship API calls.", "counter")
+	fmt.Fprintf(&b, "build_waiter_api_retries_total %d\n", r.retries)
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+func writeHeader(b *strings.Builder, name, help, kind string) {
+	fmt.Fprintf(b, "# HELP %s %s\n# TYPE %s %s\n", name, help, name, kind)
+}
+
+func writeHistogram(b *strings.Builder, name, labels string, h *histogram) {
+	for i, upper := range h.buckets {
+		fmt.Fprintf(b, "%s_bucket{%s,le=\"%g\"} %d\n", name, labels, upper, h.counts[i])
+	}
+	fmt.Fprintf(b, "%s_bucket{%s,le=\"+Inf\"} %d\n", name, labels, h.count)
+	fmt.Fprintf(b, "%s_sum{%s} %g\n", name, labels, h.sum)
+	fmt.Fprintf(b, "%s_count{%s} %d\n", name, labels, h.count)
+}
+
+// histogramKeys returns the labels of a set of histograms in order.
+func histogramKeys(m map[string]*histogram) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// counterKeys returns the labels of a set of counters in order.
+func counterKeys(m map[string]uint64) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// serveMetrics serves the metrics on addr until ctx is done.
+func serveMetrics(ctx context.Context, addr string) {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		_ = metrics.writeTo(w)
+	})
+
+	srv := &http.Server{Addr: addr, Handler: mux}
+	go func() {
+		<-ctx.Done()
+		_ = srv.Close()
+	}()
+
+	go func() {
+		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
+			logError(eventError, fields{"metrics_addr": addr}, "Serving metrics on %s failed: %v", addr, err)
+		}
+	}()
+}
+
+// writeMetricsFile writes the metrics to path for the node_exporter textfile
+// collector.
+func writeMetricsFile(path string) error {
+	return writeFileAtomic(path, metrics.writeTo)
+}
+
+// instrumentedOrganization records metrics for every call to the Codeship
+// API made through it.
+type instrumentedOrganization struct {
+	org *codeship.Organization
+}
+
+func observe(method string, start time.Time, err error) {
+	metrics.observeAPICall(method, time.Since(start), err)
+}
+
+func (o instrumentedOrganization) GetBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, codeship.Response, error) {
+	start := time.Now()
+	build, resp, err := o.org.GetBuild(ctx, projectUUID, buildUUID)
+	observe("GetBuild", start, err)
+	return build, resp, err
+}
+
+func (o instrumentedOrganization) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	start := time.Now()
+	builds, resp, err := o.org.ListBuilds(ctx, projectUUID, opts...)
+	observe("ListBuilds", start, err)
+	return builds, resp, err
+}
+
+func (o instrumentedOrganization) StopBuild(ctx context.Context, projectUUID, buildUUID string) (bool, codeship.Response, error) {
+	start := time.Now()
+	ok, resp, err := o.org.StopBuild(ctx, projectUUID, buildUUID)
+	observe("StopBuild", start, err)
+	return ok, resp, err
+}
+
+func (o instrumentedOrganization) RestartBuild(ctx context.Context, projectUUID, buildUUID string) (bool, codeship.Response, error) {
+	start := time.Now()
+	ok, resp, err := o.org.RestartBuild(ctx, projectUUID, buildUUID)
+	observe("RestartBuild", start, err)
+	return ok, resp, err
+}
+
+func (o instrumentedOrganization) CreateBuild(ctx context.Context, projectUUID, ref, commitSha string) (bool, codeship.Response, error) {
+	start := time.Now()
+	ok, resp, err := o.org.CreateBuild(ctx, projectUUID, ref, commitSha)
+	observe("CreateBuild", start, err)
+	return ok, resp, err
+}
+
+func (o instrumentedOrganization) ListBuildSteps(ctx context.Context, projectUUID, buildUUID string, opts ...codeship.PaginationOption) (codeship.BuildSteps, codeship.Response, error) {
+	start := time.Now()
+	steps, resp, err := o.org.ListBuildSteps(ctx, projectUUID, buildUUID, opts...)
+	observe("ListBuildSteps", start, err)
+	return steps, resp, err
+}
+
+func (o instrumentedOrganization) ListBuildPipelines(ctx context.Context, projectUUID, buildUUID string, opts ...codeship.PaginationOption) (codeship.BuildPipelines, codeship.Response, error) {
+	start := time.Now()
+	pipelines, resp, err := o.org.ListBuildPipelines(ctx, projectUUID, buildUUID, opts...)
+	observe("ListBuildPipelines", start, err)
+	return pipelines, resp, err
+}
+
+func (o instrumentedOrganization) GetProject(ctx context.Context, projectUUID string) (codeship.Project, codeship.Response, error) {
+	start := time.Now()
+	project, resp, err := o.org.GetProject(ctx, projectUUID)
+	observe("GetProject", start, err)
+	return project, resp, err
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	pkgerrors "github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHistogram(t *testing.T) {
+	h := newHistogram([]float64{1, 5, 10})
+	h.observe(0.5)
+	h.observe(3)
+	h.observe(20)
+
+	assert.Equal(t, []uint64{1, 2, 2}, h.counts)
+	assert.Equal(t, uint64(3), h.count)
+	assert.Equal(t, 23.5, h.sum)
+}
+
+func TestMetricsRegistry(t *testing.T) {
+	r := newMetricsRegistry()
+	r.observeAPICall("GetBuild", 200*time.Millisecond, nil)
+	r.observeAPICall("GetBuild", time.Second, pkgerrors.Wrap(codeship.ErrRateLimitExceeded, "get build"))
+	r.observeAPICall("ListBuilds", time.Second, errors.New("boom"))
+	r.observeRetry()
+	r.setQueueDepth(3)
+	r.observeOutcome("success", 90*time.Second)
+
+	var buf bytes.Buffer
+	require.NoError(t, r.writeTo(&buf))
+
+	out := buf.String()
+	assert.Contains(t, out, "# TYPE build_waiter_wait_duration_seconds histogram\n")
+	assert.Contains(t, out, `build_waiter_wait_duration_seconds_bucket{outcome="success",le="60"} 0`+"\n")
+	assert.Contains(t, out, `build_waiter_wait_duration_seconds_bucket{outcome="success",le="120"} 1`+"\n")
+	assert.Contains(t, out, `build_waiter_wait_duration_seconds_sum{outcome="success"} 90`+"\n")
+	assert.Contains(t, out, `build_waiter_outcomes_total{outcome="success"} 1`+"\n")
+	assert.Contains(t, out, "build_waiter_queue_depth 3\n")
+	assert.Contains(t, out, `build_waiter_api_calls_total{method="GetBuild",result="ok"} 1`+"\n")
+	assert.Contains(t, out, `build_waiter_api_calls_total{method="GetBuild",result="rate_limited"} 1`+"\n")
+	assert.Contains(t, out, `build_waiter_api_calls_total{method="ListBuilds",result="error"} 1`+"\n")
+	assert.Contains(t, out, `build_waiter_api_call_duration_seconds_count{method="GetBuild"} 2`+"\n")
+	assert.Contains(t, out, "build_waiter_api_retries_total 1\n")
+}
+
+func TestOutcome(t *testing.T) {
+	assert.Equal(t, "success", outcome(nil))
+	assert.Equal(t, "timeout", outcome(timeoutError{}))
+	assert.Equal(t, "superseded", outcome(supersededError{}))
+	assert.Equal(t, "predecessor_failed", outcome(previousFailedError{}))
+	assert.Equal(t, "build_failed", outcome(buildFailedError{}))
+	assert.Equal(t, "error", outcome(errors.New("boom")))
+}
+
+func TestWriteMetricsFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-waiter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "build_waiter.prom")
+	require.NoError(t, writeMetricsFile(path))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+	assert.Contains(t, string(content), "build_waiter_api_retries_total")
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
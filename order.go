@@ -0,0 +1,184 @@
+package main
+
+import (
+	"os/exec"
+	"sort"
+	"sync"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+type buildOrder string
+
+const (
+	orderQueued    buildOrder = "queued"
+	orderAllocated buildOrder = "allocated"
+	orderAncestry  buildOrder = "ancestry"
+)
+
+// timeBefore orders a before b, with zero times after every set time so a
+// build that hasn't been queued or allocated yet can't jump the queue.
+func timeBefore(a, b time.Time) bool {
+	switch {
+	case a.IsZero():
+		return false
+	case b.IsZero():
+		return true
+	}
+	return a.Before(b)
+}
+
+// compareTimes orders builds by t, falling back to UUID when the times are
+// equal so the order is deterministic.
+func compareTimes(a, b codeship.Build, t func(codeship.Build) time.Time) bool {
+	if timeBefore(t(a), t(b)) {
+		return true
+	}
+	if timeBefore(t(b), t(a)) {
+		return false
+	}
+	return a.UUID < b.UUID
+}
+
+func allocatedAt(b codeship.Build) time.Time {
+	return b.AllocatedAt
+}
+
+func queuedAt(b codeship.Build) time.Time {
+	return b.QueuedAt
+}
+
+type allocatedAtSort []codeship.Build
+
+func (s allocatedAtSort) Len() int {
+	return len(s)
+}
+
+func (s allocatedAtSort) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s allocatedAtSort) Less(i, j int) bool {
+	return compareTimes(s[i], s[j], allocatedAt)
+}
+
+type queuedAtSort []codeship.Build
+
+func (s queuedAtSort) Len() int {
+	return len(s)
+}
+
+func (s queuedAtSort) Swap(i, j int) {
+	s[i], s[j] = s[j], s[i]
+}
+
+func (s queuedAtSort) Less(i, j int) bool {
+	return compareTimes(s[i], s[j], queuedAt)
+}
+
+// ancestryOrder sorts builds so every build comes before the builds whose
+// commits descend from its commit, and otherwise keeps them in queue order.
+//
+// This is a topological sort rather than sort.Sort with an ancestry
+// comparison: falling back to queue times for unrelated commits doesn't make
+// a strict weak ordering, so such a comparison could loop. Among the builds
+// whose ancestors are all placed, the one queued first goes next.
+func ancestryOrder(builds []codeship.Build, isAncestor func(ancestor, descendant string) bool) {
+	sort.Sort(queuedAtSort(builds))
+
+	// ancestors[i] counts the builds that must come before builds[i] and
+	// descendants[i] lists the builds that must come after it
+	ancestors := make([]int, len(builds))
+	descendants := make([][]int, len(builds))
+	for i, a := range builds {
+		for j, b := range builds {
+			if a.CommitSha == "" || b.CommitSha == "" || a.CommitSha == b.CommitSha {
+				continue
+			}
+			if isAncestor(a.CommitSha, b.CommitSha) {
+				descendants[i] = append(descendants[i], j)
+				ancestors[j]++
+			}
+		}
+	}
+
+	sorted := make([]codeship.Build, 0, len(builds))
+	placed := make([]bool, len(builds))
+	for len(sorted) < len(builds) {
+		next := -1
+		for i := range builds {
+			if !placed[i] && ancestors[i] <= 0 {
+				next = i
+				break
+			}
+		}
+		if next < 0 {
+			// the history has a cycle, which git never reports, so fall
+			// back to queue order for what is left
+			for i := range builds {
+				if !placed[i] {
+					next = i
+					break
+				}
+			}
+		}
+
+		placed[next] = true
+		sorted = append(sorted, builds[next])
+		for _, j := range descendants[next] {
+			ancestors[j]--
+		}
+	}
+
+	copy(builds, sorted)
+}
+
+// cachedIsAncestor remembers the answers of isAncestor, which never change
+// for a pair of commits, so git runs once per pair instead of once per
+// comparison on every poll.
+func cachedIsAncestor(isAncestor func(ancestor, descendant string) bool) func(ancestor, descendant string) bool {
+	var (
+		mu    sync.Mutex
+		cache = make(map[[2]string]bool)
+	)
+	return func(ancestor, descendant string) bool {
+		key := [2]string{ancestor, descendant}
+
+		mu.Lock()
+		related, ok := cache[key]
+		mu.Unlock()
+		if ok {
+			return related
+		}
+
+		related = isAncestor(ancestor, descendant)
+
+		mu.Lock()
+		cache[key] = related
+		mu.Unlock()
+		return related
+	}
+}
+
+// gitIsAncestor uses the checked out repository to check whether ancestor
+// is an ancestor of descendant. Commits git doesn't know are unrelated.
+func gitIsAncestor(ancestor, descendant string) bool {
+	return exec.Command("git", "merge-base", "--is-ancestor", ancestor, descendant).Run() == nil
+}
+
+// sortBuilds sorts builds oldest first using the configured order.
+func (m monitor) sortBuilds(builds []codeship.Build) {
+	switch m.order {
+	case orderQueued:
+		sort.Sort(queuedAtSort(builds))
+	case orderAncestry:
+		isAncestor := m.isAncestor
+		if isAncestor == nil {
+			isAncestor = gitIsAncestor
+		}
+		ancestryOrder(builds, isAncestor)
+	default:
+		sort.Sort(allocatedAtSort(builds))
+	}
+}
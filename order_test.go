@@ -0,0 +1,126 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSortBuilds(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name     string
+		order    buildOrder
+		builds   []codeship.Build
+		expected []string
+	}{
+		{
+			name:  "allocated with unallocated build",
+			order: orderAllocated,
+			builds: []codeship.Build{
+				{UUID: "c"},
+				{UUID: "b", AllocatedAt: now},
+				{UUID: "a", AllocatedAt: now.Add(-time.Minute)},
+			},
+			expected: []string{"a", "b", "c"},
+		}, {
+			name:  "allocated tie broken by uuid",
+			order: orderAllocated,
+			builds: []codeship.Build{
+				{UUID: "b", AllocatedAt: now},
+				{UUID: "a", AllocatedAt: now},
+			},
+			expected: []string{"a", "b"},
+		}, {
+			name:  "queued before allocated",
+			order: orderQueued,
+			builds: []codeship.Build{
+				{UUID: "a", QueuedAt: now, AllocatedAt: now.Add(time.Minute)},
+				{UUID: "b", QueuedAt: now.Add(time.Second), AllocatedAt: now.Add(time.Second)},
+				{UUID: "c"},
+				{UUID: "d", QueuedAt: now.Add(-time.Second)},
+			},
+			expected: []string{"d", "a", "b", "c"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				order: tc.order,
+			}
+
+			monitor.sortBuilds(tc.builds)
+			assert.Equal(t, tc.expected, uuids(tc.builds))
+		})
+	}
+}
+
+func TestSortBuildsByAncestry(t *testing.T) {
+	now := time.Now()
+
+	// history: first <- second <- third, other is unrelated
+	history := map[string][]string{
+		"third":  {"second", "first"},
+		"second": {"first"},
+	}
+
+	monitor := &monitor{
+		order: orderAncestry,
+		isAncestor: func(ancestor, descendant string) bool {
+			for _, c := range history[descendant] {
+				if c == ancestor {
+					return true
+				}
+			}
+			return false
+		},
+	}
+
+	builds := []codeship.Build{
+		{UUID: "1", CommitSha: "third", QueuedAt: now.Add(-time.Minute)},
+		{UUID: "2", CommitSha: "first", QueuedAt: now},
+		{UUID: "3", CommitSha: "second", QueuedAt: now.Add(-2 * time.Minute)},
+	}
+
+	monitor.sortBuilds(builds)
+	assert.Equal(t, []string{"2", "3", "1"}, uuids(builds))
+}
+
+func TestSortBuildsByAncestryAndQueueTime(t *testing.T) {
+	now := time.Now()
+
+	// a is an ancestor of c, b is unrelated. Comparing pairs, c < b and b < a
+	// by queue time but a < c by ancestry.
+	monitor := &monitor{
+		order: orderAncestry,
+		isAncestor: func(ancestor, descendant string) bool {
+			return ancestor == "a" && descendant == "c"
+		},
+	}
+
+	builds := []codeship.Build{
+		{UUID: "a", CommitSha: "a", QueuedAt: now},
+		{UUID: "b", CommitSha: "b", QueuedAt: now.Add(-time.Minute)},
+		{UUID: "c", CommitSha: "c", QueuedAt: now.Add(-2 * time.Minute)},
+	}
+
+	monitor.sortBuilds(builds)
+	assert.Equal(t, []string{"b", "a", "c"}, uuids(builds))
+}
+
+func TestCachedIsAncestor(t *testing.T) {
+	calls := 0
+	isAncestor := cachedIsAncestor(func(ancestor, descendant string) bool {
+		calls++
+		return ancestor == "first"
+	})
+
+	assert.True(t, isAncestor("first", "second"))
+	assert.True(t, isAncestor("first", "second"))
+	assert.False(t, isAncestor("second", "first"))
+	assert.Equal(t, 2, calls)
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"context"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+type pipelineLister interface {
+	ListBuildPipelines(ctx context.Context, projectUUID, buildUUID string, opts ...codeship.PaginationOption) (codeship.BuildPipelines, codeship.Response, error)
+}
+
+// pipelineFinished reports whether every pipeline of the gated type of a
+// running build has finished. A pipeline that hasn't shown up yet hasn't
+// finished.
+func (m monitor) pipelineFinished(ctx context.Context, b codeship.Build) (bool, error) {
+	pipelines, err := m.listPipelines(ctx, b)
+	if err != nil {
+		return false, err
+	}
+
+	found := false
+	for _, p := range pipelines {
+		if p.Type != m.pipeline {
+			continue
+		}
+		found = true
+		if m.pipelineState(p).active() {
+			return false, nil
+		}
+	}
+
+	return found, nil
+}
+
+// listPipelines returns all pipelines of a build, following pagination.
+func (m monitor) listPipelines(ctx context.Context, b codeship.Build) ([]codeship.BuildPipeline, error) {
+	var (
+		all  []codeship.BuildPipeline
+		opts []codeship.PaginationOption
+	)
+
+	for {
+		var (
+			pipelines codeship.BuildPipelines
+			resp      codeship.Response
+		)
+		err := m.retry(ctx, "list pipelines of build "+b.UUID, func() (codeship.Response, error) {
+			var err error
+			pipelines, resp, err = m.ListBuildPipelines(ctx, b.ProjectUUID, b.UUID, opts...)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, pipelines.Pipelines...)
+
+		if resp.IsLastPage() || resp.Next == "" {
+			return all, nil
+		}
+
+		next, err := resp.NextPage()
+		if err != nil {
+			return nil, err
+		}
+		opts = []codeship.PaginationOption{codeship.Page(next), codeship.PerPage(50)}
+	}
+}
+
+// pipelineState classifies a pipeline status like a build status.
+func (m monitor) pipelineState(p codeship.BuildPipeline) buildState {
+	return m.state(codeship.Build{Status: p.Status})
+}
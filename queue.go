@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+// buildQueue is the queue of running builds on the branch, split around our
+// own build. Both ahead and behind are ordered oldest first.
+type buildQueue struct {
+	self   codeship.Build
+	ahead  []codeship.Build
+	behind []codeship.Build
+}
+
+// position returns our 1-based position in the queue.
+func (q buildQueue) position() int {
+	return len(q.ahead) + 1
+}
+
+// queue returns the queue of running builds on the branch around our build.
+//
+// Our build can be missing from the listed builds, e.g. when it is on a page
+// we didn't fetch, its status doesn't count as queued or running yet or it
+// was just restarted. In that case it is fetched directly and placed in the
+// queue by its own timestamps so we never wait on builds that started after
+// ours.
+//
+// With a lock the queue spans the running builds of every project sharing
+// the lock, ordered globally.
+func (m monitor) queue(ctx context.Context, projectUUID, buildUUID, branch string) (buildQueue, error) {
+	watching, err := m.queuedBuilds(ctx, projectUUID, branch)
+	if err != nil {
+		return buildQueue{}, err
+	}
+
+	if indexOf(watching, buildUUID) < 0 {
+		self, err := m.getBuild(ctx, projectUUID, buildUUID)
+		if err != nil {
+			return buildQueue{}, err
+		}
+
+		logWarn(eventWarning, fields{"build_uuid": buildUUID, "status": self.Status, "branch": branch}, "Warning: build %s (status %s) is missing from the running builds on branch %s, placing it by its own timestamps", buildUUID, self.Status, branch)
+		watching = append(watching, self)
+
+		// queuedBuilds sorted the listed builds, place ours among them
+		m.sortBuilds(watching)
+	}
+
+	i := indexOf(watching, buildUUID)
+	return buildQueue{
+		self:   watching[i],
+		ahead:  watching[:i],
+		behind: watching[i+1:],
+	}, nil
+}
+
+// queuedBuilds returns the running builds on the branch of our project and,
+// with a lock, the running builds on any branch of the lock's projects,
+// oldest first.
+func (m monitor) queuedBuilds(ctx context.Context, projectUUID, branch string) ([]codeship.Build, error) {
+	var watching []codeship.Build
+	for _, uuid := range m.projects(projectUUID) {
+		// the lock is held by builds of the other projects whatever their branch
+		projectBranch := branch
+		if uuid != projectUUID {
+			projectBranch = ""
+		}
+
+		builds, err := m.buildsToWatch(ctx, uuid, projectBranch)
+		if err != nil {
+			return nil, err
+		}
+		for _, b := range builds {
+			// builds we superseded may still be listed until they stop
+			if !contains(m.superseded, b.UUID) {
+				watching = append(watching, b)
+			}
+		}
+	}
+
+	m.sortBuilds(watching)
+	return watching, nil
+}
+
+// projects returns the projects whose builds make up the queue: our own and,
+// with a lock, the lock's projects.
+func (m monitor) projects(projectUUID string) []string {
+	projects := []string{projectUUID}
+	if m.lock == "" {
+		return projects
+	}
+	for _, uuid := range m.lockProjects {
+		if uuid != projectUUID {
+			projects = append(projects, uuid)
+		}
+	}
+	return projects
+}
+
+// contains reports whether s is one of list.
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// indexOf returns the index of the build with uuid, or -1 if there is none.
+func indexOf(builds []codeship.Build, uuid string) int {
+	for i, b := range builds {
+		if b.UUID == uuid {
+			return i
+		}
+	}
+	return -1
+}
+
+// uuids returns the UUIDs of builds.
+func uuids(builds []codeship.Build) []string {
+	var ids []string
+	for _, b := range builds {
+		ids = append(ids, b.UUID)
+	}
+	return ids
+}
@@ -0,0 +1,238 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueue(t *testing.T) {
+	now := time.Now()
+	older := codeship.Build{UUID: "older", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)}
+	newer := codeship.Build{UUID: "newer", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(time.Minute)}
+	self := codeship.Build{UUID: "self", Status: "testing", Branch: "test-branch", QueuedAt: now}
+
+	testCases := []struct {
+		name   string
+		builds mockBuildList
+		ahead  []string
+		behind []string
+	}{
+		{
+			name: "listed",
+			builds: mockBuildList{
+				builds: []codeship.Build{newer, self, older},
+			},
+			ahead:  []string{"older"},
+			behind: []string{"newer"},
+		}, {
+			name: "missing from list",
+			builds: mockBuildList{
+				builds:   []codeship.Build{newer, older},
+				unlisted: []codeship.Build{self},
+			},
+			ahead:  []string{"older"},
+			behind: []string{"newer"},
+		}, {
+			name: "missing and first",
+			builds: mockBuildList{
+				builds:   []codeship.Build{newer},
+				unlisted: []codeship.Build{self},
+			},
+			behind: []string{"newer"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				buildGetter: tc.builds,
+				order:       orderQueued,
+			}
+
+			q, err := monitor.queue(context.TODO(), "project-uuid", "self", "test-branch")
+			require.NoError(t, err)
+			assert.Equal(t, "self", q.self.UUID)
+			assert.Equal(t, tc.ahead, uuids(q.ahead))
+			assert.Equal(t, tc.behind, uuids(q.behind))
+			assert.Equal(t, len(tc.ahead)+1, q.position())
+		})
+	}
+}
+
+// mockBuildSnapshots serves a different list of builds on every ListBuilds
+// call, repeating the last one. Builds missing from the current snapshot
+// have succeeded.
+type mockBuildSnapshots struct {
+	snapshots [][]codeship.Build
+	calls     int
+}
+
+// snapshot returns the i-th snapshot, or the last one past the end.
+func (m *mockBuildSnapshots) snapshot(i int) []codeship.Build {
+	if i >= len(m.snapshots) {
+		i = len(m.snapshots) - 1
+	}
+	return m.snapshots[i]
+}
+
+func (m *mockBuildSnapshots) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	builds := m.snapshot(m.calls)
+	m.calls++
+	return codeship.BuildList{
+		Builds: builds,
+	}, codeship.Response{}, nil
+}
+
+func (m *mockBuildSnapshots) GetBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, codeship.Response, error) {
+	for _, b := range m.snapshot(m.calls - 1) {
+		if b.UUID == buildUUID {
+			return b, codeship.Response{}, nil
+		}
+	}
+	return codeship.Build{UUID: buildUUID, Status: "success"}, codeship.Response{}, nil
+}
+
+func TestWaitOnPreviousBuildsRecomputesQueue(t *testing.T) {
+	now := time.Now()
+	first := codeship.Build{UUID: "first", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-2 * time.Minute)}
+	late := codeship.Build{UUID: "late", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)}
+	self := codeship.Build{UUID: "self", Status: "testing", Branch: "test-branch", QueuedAt: now}
+
+	builds := &mockBuildSnapshots{
+		snapshots: [][]codeship.Build{
+			{first, self},
+			// late was queued before us but only shows up once allocated
+			{first, late, self},
+			{late, self},
+			{self},
+		},
+	}
+
+	monitor := &monitor{
+		buildGetter: builds,
+		order:       orderQueued,
+		poll:        backoff{interval: time.Millisecond},
+	}
+
+	err := monitor.waitOnPreviousBuilds(context.TODO(), "project-uuid", "self", "test-branch")
+	require.NoError(t, err)
+	assert.Equal(t, 4, builds.calls)
+}
+
+func TestStillAheadRestartedBuild(t *testing.T) {
+	now := time.Now()
+	running := codeship.Build{UUID: "first", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)}
+	finished := running
+	finished.Status = "success"
+
+	var (
+		waitingOn = make(map[string]codeship.Build)
+		passed    = make(map[string]time.Time)
+	)
+
+	polls := []struct {
+		name   string
+		builds mockBuildList
+		ahead  []string
+	}{
+		{
+			name:   "running",
+			builds: mockBuildList{builds: []codeship.Build{running}},
+			ahead:  []string{"first"},
+		}, {
+			name:   "finished",
+			builds: mockBuildList{unlisted: []codeship.Build{finished}},
+		}, {
+			name:   "restarted",
+			builds: mockBuildList{builds: []codeship.Build{running}},
+			ahead:  []string{"first"},
+		},
+	}
+
+	for _, p := range polls {
+		monitor := &monitor{
+			buildGetter: p.builds,
+			order:       orderQueued,
+		}
+
+		ahead, err := monitor.stillAhead(context.TODO(), p.builds.builds, 1, waitingOn, passed)
+		require.NoError(t, err)
+		assert.Equal(t, p.ahead, uuids(ahead), p.name)
+	}
+}
+
+// mockProjectBuilds serves the running builds of several projects.
+type mockProjectBuilds map[string][]codeship.Build
+
+func (m mockProjectBuilds) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	return codeship.BuildList{
+		Builds: m[projectUUID],
+	}, codeship.Response{}, nil
+}
+
+func (m mockProjectBuilds) GetBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, codeship.Response, error) {
+	for _, b := range m[projectUUID] {
+		if b.UUID == buildUUID {
+			return b, codeship.Response{}, nil
+		}
+	}
+	return codeship.Build{}, codeship.Response{}, codeship.ErrNotFound{}
+}
+
+func TestQueueLock(t *testing.T) {
+	now := time.Now()
+	builds := mockProjectBuilds{
+		"api": {
+			{UUID: "api-1", ProjectUUID: "api", Status: "testing", Branch: "master", QueuedAt: now.Add(-2 * time.Minute)},
+			{UUID: "api-feature", ProjectUUID: "api", Status: "testing", Branch: "feature", QueuedAt: now.Add(-time.Minute)},
+			{UUID: "self", ProjectUUID: "api", Status: "testing", Branch: "master", QueuedAt: now},
+		},
+		"web": {
+			{UUID: "web-1", ProjectUUID: "web", Status: "testing", Branch: "master", QueuedAt: now.Add(-time.Minute)},
+			{UUID: "web-2", ProjectUUID: "web", Status: "testing", Branch: "master", QueuedAt: now.Add(time.Minute)},
+			{UUID: "web-deploy", ProjectUUID: "web", Status: "testing", Branch: "deploy", QueuedAt: now.Add(-30 * time.Second)},
+		},
+		"docs": {
+			{UUID: "docs-1", ProjectUUID: "docs", Status: "testing", Branch: "master", QueuedAt: now.Add(-3 * time.Minute)},
+		},
+	}
+
+	testCases := []struct {
+		name   string
+		lock   string
+		ahead  []string
+		behind []string
+	}{
+		{
+			name:  "without lock",
+			ahead: []string{"api-1"},
+		}, {
+			// builds of the other projects hold the lock on any branch
+			name:   "with lock",
+			lock:   "staging",
+			ahead:  []string{"api-1", "web-1", "web-deploy"},
+			behind: []string{"web-2"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				buildGetter:  builds,
+				order:        orderQueued,
+				lock:         tc.lock,
+				lockProjects: []string{"api", "web"},
+			}
+
+			q, err := monitor.queue(context.TODO(), "api", "self", "master")
+			require.NoError(t, err)
+			assert.Equal(t, tc.ahead, uuids(q.ahead))
+			assert.Equal(t, tc.behind, uuids(q.behind))
+		})
+	}
+}
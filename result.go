@@ -0,0 +1,275 @@
+package main
+
+import (
+	"encoding/json"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+// Decisions recorded in the result file.
+const (
+	decisionResumed     = "resumed"
+	decisionTimeout     = "timeout"
+	decisionSuperseded  = "superseded"
+	decisionFailed      = "failed"
+	decisionInterrupted = "interrupted"
+)
+
+// waitResult is the document written to --result-file.
+type waitResult struct {
+	BuildUUID        string              `json:"build_uuid"`
+	ProjectUUID      string              `json:"project_uuid"`
+	Branch           string              `json:"branch"`
+	CommitSha        string              `json:"commit_sha"`
+	Predecessors     []predecessorResult `json:"predecessors"`
+	TotalWaitSeconds float64             `json:"total_wait_seconds"`
+	Decision         string              `json:"decision"`
+	ExitCode         int                 `json:"exit_code"`
+	Error            string              `json:"error,omitempty"`
+}
+
+// predecessorResult is a build we waited on.
+type predecessorResult struct {
+	UUID          string  `json:"uuid"`
+	ProjectUUID   string  `json:"project_uuid"`
+	CommitSha     string  `json:"commit_sha"`
+	Status        string  `json:"status"`
+	Stopped       bool    `json:"stopped,omitempty"`
+	WaitedSeconds float64 `json:"waited_seconds"`
+}
+
+// waitReport records what the waiter did for the result file. A nil report
+// records nothing.
+type waitReport struct {
+	mu       sync.Mutex
+	start    time.Time
+	self     codeship.Build
+	timedOut bool
+
+	predecessors map[string]*predecessor
+}
+
+type predecessor struct {
+	build    codeship.Build
+	since    time.Time
+	finished time.Time
+	stopped  bool
+}
+
+func newWaitReport() *waitReport {
+	return &waitReport{
+		start:        time.Now(),
+		predecessors: make(map[string]*predecessor),
+	}
+}
+
+// setBuild records our own build.
+func (r *waitReport) setBuild(b codeship.Build) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.self = b
+}
+
+// waitingOn records that we are waiting on builds, starting the clock for
+// the ones we weren't waiting on yet.
+func (r *waitReport) waitingOn(builds []codeship.Build) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	for _, b := range builds {
+		p, ok := r.predecessors[b.UUID]
+		if !ok {
+			r.predecessors[b.UUID] = &predecessor{build: b, since: now}
+			continue
+		}
+		p.build.Status = b.Status
+		p.finished = time.Time{}
+	}
+}
+
+// update records the latest status of b if we are waiting on it.
+func (r *waitReport) update(b codeship.Build) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.predecessors[b.UUID]; ok {
+		p.build.Status = b.Status
+	}
+}
+
+// passed records that the build with uuid no longer holds us back.
+func (r *waitReport) passed(uuid string) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if p, ok := r.predecessors[uuid]; ok && p.finished.IsZero() {
+		p.finished = time.Now()
+	}
+}
+
+// stopped records that we stopped b, which counts as a predecessor even if
+// we never waited on it.
+func (r *waitReport) stopped(b codeship.Build) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if b.UUID == r.self.UUID {
+		return
+	}
+
+	now := time.Now()
+	p, ok := r.predecessors[b.UUID]
+	if !ok {
+		p = &predecessor{build: b, since: now}
+		r.predecessors[b.UUID] = p
+	}
+	p.build.Status = "stopped"
+	p.stopped = true
+	if p.finished.IsZero() {
+		p.finished = now
+	}
+}
+
+// timeout records that the timeout policy fired, even if it let us proceed.
+func (r *waitReport) timeout() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.timedOut = true
+}
+
+// result returns the result of a run that ended with err and exit code. A
+// nil report, as when the configuration is invalid, only describes the
+// outcome.
+func (r *waitReport) result(err error, code int) waitResult {
+	res := waitResult{
+		Predecessors: []predecessorResult{},
+		Decision:     decision(err),
+		ExitCode:     code,
+	}
+	if err != nil {
+		res.Error = err.Error()
+	}
+	if r == nil {
+		return res
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if err == nil && r.timedOut {
+		res.Decision = decisionTimeout
+	}
+
+	now := time.Now()
+	res.BuildUUID = r.self.UUID
+	res.ProjectUUID = r.self.ProjectUUID
+	res.Branch = r.self.Branch
+	res.CommitSha = r.self.CommitSha
+	res.TotalWaitSeconds = now.Sub(r.start).Seconds()
+
+	// in the order we started waiting on them
+	preds := make([]*predecessor, 0, len(r.predecessors))
+	for _, p := range r.predecessors {
+		preds = append(preds, p)
+	}
+	sort.Slice(preds, func(i, j int) bool {
+		if !preds[i].since.Equal(preds[j].since) {
+			return preds[i].since.Before(preds[j].since)
+		}
+		return preds[i].build.UUID < preds[j].build.UUID
+	})
+
+	for _, p := range preds {
+		end := p.finished
+		if end.IsZero() {
+			end = now
+		}
+		res.Predecessors = append(res.Predecessors, predecessorResult{
+			UUID:          p.build.UUID,
+			ProjectUUID:   p.build.ProjectUUID,
+			CommitSha:     p.build.CommitSha,
+			Status:        p.build.Status,
+			Stopped:       p.stopped,
+			WaitedSeconds: end.Sub(p.since).Seconds(),
+		})
+	}
+
+	return res
+}
+
+// decision names what the waiter decided for a run that ended with err.
+func decision(err error) string {
+	switch err.(type) {
+	case nil:
+		return decisionResumed
+	case interruptedError:
+		return decisionInterrupted
+	case timeoutError:
+		return decisionTimeout
+	case supersededError:
+		return decisionSuperseded
+	}
+	return decisionFailed
+}
+
+// writeResultFile writes res to path as JSON.
+func writeResultFile(path string, res waitResult) error {
+	return writeFileAtomic(path, func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(res)
+	})
+}
+
+// writeFileAtomic writes a file through a temporary file in the same
+// directory that is renamed into place, so readers never see it half
+// written.
+func writeFileAtomic(path string, write func(io.Writer) error) error {
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := write(tmp); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Sync(); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	if err := os.Chmod(tmp.Name(), 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp.Name(), path)
+}
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitReport(t *testing.T) {
+	now := time.Now()
+	first := codeship.Build{UUID: "first", CommitSha: "abc", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-2 * time.Minute)}
+	second := codeship.Build{UUID: "second", CommitSha: "def", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)}
+	self := codeship.Build{UUID: "self", ProjectUUID: "project-uuid", CommitSha: "123", Status: "testing", Branch: "test-branch", QueuedAt: now}
+
+	builds := &mockBuildSnapshots{
+		snapshots: [][]codeship.Build{
+			{first, second, self},
+			{second, self},
+			{self},
+		},
+	}
+
+	monitor := &monitor{
+		buildGetter: builds,
+		order:       orderQueued,
+		poll:        backoff{interval: time.Millisecond},
+		report:      newWaitReport(),
+	}
+	monitor.report.setBuild(self)
+
+	err := monitor.waitOnPreviousBuilds(context.TODO(), "project-uuid", "self", "test-branch")
+	require.NoError(t, err)
+
+	res := monitor.report.result(nil, 0)
+	assert.Equal(t, "self", res.BuildUUID)
+	assert.Equal(t, "project-uuid", res.ProjectUUID)
+	assert.Equal(t, "test-branch", res.Branch)
+	assert.Equal(t, "123", res.CommitSha)
+	assert.Equal(t, decisionResumed, res.Decision)
+	assert.Equal(t, 0, res.ExitCode)
+	assert.Equal(t, "", res.Error)
+
+	require.Len(t, res.Predecessors, 2)
+	assert.Equal(t, "first", res.Predecessors[0].UUID)
+	assert.Equal(t, "abc", res.Predecessors[0].CommitSha)
+	assert.Equal(t, "success", res.Predecessors[0].Status)
+	assert.Equal(t, "second", res.Predecessors[1].UUID)
+	assert.Equal(t, "success", res.Predecessors[1].Status)
+}
+
+func TestWaitReportStopped(t *testing.T) {
+	testCases := []struct {
+		name     string
+		wait     func(m *monitor) error
+		decision string
+		stopped  []string
+	}{
+		{
+			name: "timeout stop policy",
+			wait: func(m *monitor) error {
+				m.maxWait = 10 * time.Millisecond
+				m.onTimeout = timeoutStop
+				return m.waitOnPreviousBuilds(context.TODO(), "project-uuid", "build-uuid", "test-branch")
+			},
+			decision: decisionTimeout,
+			stopped:  []string{"1", "2"},
+		}, {
+			name: "timeout proceed policy",
+			wait: func(m *monitor) error {
+				m.maxWait = 10 * time.Millisecond
+				m.onTimeout = timeoutProceed
+				return m.waitOnPreviousBuilds(context.TODO(), "project-uuid", "build-uuid", "test-branch")
+			},
+			decision: decisionTimeout,
+		}, {
+			name: "supersede",
+			wait: func(m *monitor) error {
+				return m.supersedePreviousBuilds(context.TODO(), "project-uuid", "2", "test-branch")
+			},
+			decision: decisionResumed,
+			stopped:  []string{"1"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				buildGetter:  mockBuildGetter{buildStatus: "testing"},
+				buildStopper: &mockBuildStopper{},
+				report:       newWaitReport(),
+			}
+
+			require.NoError(t, tc.wait(monitor))
+
+			res := monitor.report.result(nil, 0)
+			assert.Equal(t, tc.decision, res.Decision)
+
+			var stopped []string
+			for _, p := range res.Predecessors {
+				if p.Stopped {
+					assert.Equal(t, "stopped", p.Status)
+					stopped = append(stopped, p.UUID)
+				}
+			}
+			assert.Equal(t, tc.stopped, stopped)
+		})
+	}
+}
+
+func TestNilWaitReport(t *testing.T) {
+	var r *waitReport
+	r.setBuild(codeship.Build{UUID: "self"})
+	r.waitingOn([]codeship.Build{{UUID: "first"}})
+	r.update(codeship.Build{UUID: "first", Status: "success"})
+	r.passed("first")
+	r.stopped(codeship.Build{UUID: "second"})
+	r.timeout()
+
+	res := r.result(configError("CI_BUILD_ID required"), exitConfig)
+	assert.Equal(t, decisionFailed, res.Decision)
+	assert.Equal(t, exitConfig, res.ExitCode)
+	assert.Equal(t, "CI_BUILD_ID required", res.Error)
+	assert.Empty(t, res.Predecessors)
+}
+
+func TestDecision(t *testing.T) {
+	assert.Equal(t, decisionResumed, decision(nil))
+	assert.Equal(t, decisionInterrupted, decision(interruptedError{}))
+	assert.Equal(t, decisionTimeout, decision(timeoutError{}))
+	assert.Equal(t, decisionSuperseded, decision(supersededError{}))
+	assert.Equal(t, decisionFailed, decision(previousFailedError{}))
+	assert.Equal(t, decisionFailed, decision(configError("CI_BUILD_ID required")))
+}
+
+func TestWriteResultFile(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-waiter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "result.json")
+	res := waitResult{
+		BuildUUID:        "self",
+		Predecessors:     []predecessorResult{{UUID: "first", Status: "error", WaitedSeconds: 12}},
+		TotalWaitSeconds: 12,
+		Decision:         decisionFailed,
+		ExitCode:         exitPreviousFailed,
+		Error:            "previous build failed",
+	}
+	require.NoError(t, writeResultFile(path, res))
+
+	content, err := ioutil.ReadFile(path)
+	require.NoError(t, err)
+
+	var decoded waitResult
+	require.NoError(t, json.Unmarshal(content, &decoded))
+	assert.Equal(t, res, decoded)
+
+	files, err := ioutil.ReadDir(dir)
+	require.NoError(t, err)
+	assert.Len(t, files, 1)
+}
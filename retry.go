@@ -0,0 +1,109 @@
+package main
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/pkg/errors"
+)
+
+const defaultMaxRetries = 5
+
+// defaultRetryBackoff is used between retries when the API doesn't tell us
+// how long to wait.
+var defaultRetryBackoff = backoff{
+	interval:    2 * time.Second,
+	maxInterval: time.Minute,
+	multiplier:  2,
+	jitter:      0.2,
+}
+
+// retryable reports whether a failed API call is worth retrying: the rate
+// limit was exceeded, the API had a server side error or the request failed
+// on the network.
+func retryable(resp codeship.Response, err error) bool {
+	cause := errors.Cause(err)
+	if cause == codeship.ErrRateLimitExceeded {
+		return true
+	}
+	if _, ok := cause.(net.Error); ok {
+		return true
+	}
+	return resp.Response != nil && resp.StatusCode >= http.StatusInternalServerError
+}
+
+// rateLimited reports whether a failed API call was turned away by the rate
+// limit, and so never reached the API.
+func rateLimited(resp codeship.Response, err error) bool {
+	return errors.Cause(err) == codeship.ErrRateLimitExceeded
+}
+
+// retryAfter returns how long the API asked us to wait before the next call,
+// using the Retry-After header or the rate limit reset time. It returns 0
+// when the response carries neither.
+func retryAfter(resp codeship.Response, now time.Time) time.Duration {
+	if resp.Response == nil {
+		return 0
+	}
+
+	if v := resp.Header.Get("Retry-After"); v != "" {
+		if seconds, err := strconv.Atoi(v); err == nil && seconds > 0 {
+			return time.Duration(seconds) * time.Second
+		}
+		if t, err := http.ParseTime(v); err == nil && t.After(now) {
+			return t.Sub(now)
+		}
+	}
+
+	if resp.Header.Get("X-RateLimit-Remaining") == "0" {
+		if reset, err := strconv.ParseInt(resp.Header.Get("X-RateLimit-Reset"), 10, 64); err == nil {
+			if t := time.Unix(reset, 0); t.After(now) {
+				return t.Sub(now)
+			}
+		}
+	}
+
+	return 0
+}
+
+// retry calls fn until it succeeds, fails with an error that is not
+// retryable or the retry budget is spent. op describes the call in the log.
+func (m monitor) retry(ctx context.Context, op string, fn func() (codeship.Response, error)) error {
+	return m.retryIf(ctx, op, retryable, fn)
+}
+
+// retryIf is retry with its own test of which failures are worth retrying.
+func (m monitor) retryIf(ctx context.Context, op string, retryable func(codeship.Response, error) bool, fn func() (codeship.Response, error)) error {
+	wait := m.retryBackoff
+	for attempt := 1; ; attempt++ {
+		resp, err := fn()
+		if err == nil {
+			return nil
+		}
+		// a cancelled call fails like a network error but isn't worth retrying
+		if ctx.Err() != nil {
+			return errors.Wrap(ctx.Err(), op)
+		}
+		if !retryable(resp, err) || attempt > m.maxRetries {
+			return apiError{op: op, err: err}
+		}
+
+		delay := retryAfter(resp, time.Now())
+		if delay == 0 {
+			delay = wait.next()
+		}
+
+		metrics.observeRetry()
+		logWarn(eventAPIError, fields{"op": op, "attempt": attempt, "max_retries": m.maxRetries, "retry_in_seconds": delay.Seconds(), "error": err.Error()}, "Retrying %s in %s (attempt %d/%d): %v", op, delay, attempt, m.maxRetries, err)
+
+		select {
+		case <-ctx.Done():
+			return errors.Wrap(ctx.Err(), op)
+		case <-time.After(delay):
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"strconv"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func responseWithHeader(status int, header http.Header) codeship.Response {
+	return codeship.Response{
+		Response: &http.Response{
+			StatusCode: status,
+			Header:     header,
+		},
+	}
+}
+
+func TestRetryable(t *testing.T) {
+	testCases := []struct {
+		name      string
+		resp      codeship.Response
+		err       error
+		retryable bool
+	}{
+		{
+			name:      "rate limit exceeded",
+			err:       errors.Wrap(codeship.ErrRateLimitExceeded, "unable to get build"),
+			retryable: true,
+		}, {
+			name:      "server error",
+			resp:      responseWithHeader(http.StatusBadGateway, http.Header{}),
+			err:       errors.New("HTTP status: 502"),
+			retryable: true,
+		}, {
+			name:      "not found",
+			resp:      responseWithHeader(http.StatusNotFound, http.Header{}),
+			err:       codeship.ErrNotFound{},
+			retryable: false,
+		}, {
+			name:      "unauthorized",
+			err:       codeship.ErrUnauthorized("invalid credentials"),
+			retryable: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.retryable, retryable(tc.resp, tc.err))
+		})
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	now := time.Now()
+
+	testCases := []struct {
+		name  string
+		resp  codeship.Response
+		delay time.Duration
+	}{
+		{
+			name:  "no response",
+			resp:  codeship.Response{},
+			delay: 0,
+		}, {
+			name:  "retry after seconds",
+			resp:  responseWithHeader(http.StatusTooManyRequests, http.Header{"Retry-After": []string{"7"}}),
+			delay: 7 * time.Second,
+		}, {
+			name: "rate limit reset",
+			resp: responseWithHeader(http.StatusForbidden, http.Header{
+				"X-Ratelimit-Remaining": []string{"0"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+			}),
+			delay: time.Duration(now.Add(time.Minute).Unix()-now.Unix()) * time.Second,
+		}, {
+			name: "rate limit remaining",
+			resp: responseWithHeader(http.StatusForbidden, http.Header{
+				"X-Ratelimit-Remaining": []string{"10"},
+				"X-Ratelimit-Reset":     []string{strconv.FormatInt(now.Add(time.Minute).Unix(), 10)},
+			}),
+			delay: 0,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			delay := retryAfter(tc.resp, time.Unix(now.Unix(), 0))
+			assert.Equal(t, tc.delay, delay)
+		})
+	}
+}
+
+func TestRetry(t *testing.T) {
+	testCases := []struct {
+		name     string
+		failures int
+		err      error
+		attempts int
+		fails    bool
+	}{
+		{
+			name:     "succeeds after rate limit",
+			failures: 2,
+			err:      codeship.ErrRateLimitExceeded,
+			attempts: 3,
+		}, {
+			name:     "retry budget spent",
+			failures: 10,
+			err:      codeship.ErrRateLimitExceeded,
+			attempts: 4,
+			fails:    true,
+		}, {
+			name:     "not retryable",
+			failures: 1,
+			err:      codeship.ErrUnauthorized("invalid credentials"),
+			attempts: 1,
+			fails:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				maxRetries:   3,
+				retryBackoff: backoff{interval: time.Millisecond},
+			}
+
+			attempts := 0
+			err := monitor.retry(context.TODO(), "test", func() (codeship.Response, error) {
+				attempts++
+				if attempts <= tc.failures {
+					return codeship.Response{}, tc.err
+				}
+				return codeship.Response{}, nil
+			})
+			if tc.fails {
+				require.Error(t, err)
+				_, ok := err.(apiError)
+				assert.True(t, ok)
+				assert.Equal(t, tc.err, errors.Cause(err))
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.attempts, attempts)
+		})
+	}
+}
+
+func TestRetryCancelled(t *testing.T) {
+	monitor := &monitor{
+		maxRetries:   3,
+		retryBackoff: backoff{interval: time.Millisecond},
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	before := metrics.retries
+	attempts := 0
+	err := monitor.retry(ctx, "test", func() (codeship.Response, error) {
+		attempts++
+		return codeship.Response{}, &url.Error{Op: "Get", URL: "https://api.codeship.com/v2/builds", Err: context.Canceled}
+	})
+	require.Error(t, err)
+	assert.Equal(t, context.Canceled, errors.Cause(err))
+	assert.Equal(t, 1, attempts)
+	assert.Equal(t, before, metrics.retries)
+}
@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"os"
+	"os/exec"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+)
+
+// defaultShutdownTimeout is how long an interrupted run gets to finish its
+// in-flight API call before it is forced to exit.
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdown cancels a run when the waiter is asked to stop with SIGINT,
+// SIGTERM or SIGHUP. A second signal, or a run that doesn't wind down within
+// the shutdown timeout, forces the exit.
+type shutdown struct {
+	sigs chan os.Signal
+	done chan struct{}
+
+	mu     sync.Mutex
+	signal os.Signal
+}
+
+// handleSignals calls cancel once a signal is received. timeout returns the
+// shutdown timeout; it is only called once a signal is received so the
+// configuration can be read in the meantime.
+func handleSignals(cancel context.CancelFunc, timeout func() time.Duration) *shutdown {
+	s := &shutdown{
+		sigs: make(chan os.Signal, 1),
+		done: make(chan struct{}),
+	}
+	signal.Notify(s.sigs, os.Interrupt, syscall.SIGTERM, syscall.SIGHUP)
+
+	go func() {
+		var sig os.Signal
+		select {
+		case sig = <-s.sigs:
+		case <-s.done:
+			return
+		}
+
+		s.mu.Lock()
+		s.signal = sig
+		s.mu.Unlock()
+
+		logWarn(eventSignal, fields{"signal": sig.String()}, "Received %s, stopping", sig)
+		cancel()
+
+		grace := timeout()
+		select {
+		case <-s.done:
+			return
+		case sig = <-s.sigs:
+			logError(eventInterrupted, fields{"signal": sig.String()}, "Received %s again, exiting immediately", sig)
+		case <-time.After(grace):
+			logError(eventInterrupted, fields{"shutdown_timeout": grace.String()}, "Did not stop within %s, exiting immediately", grace)
+		}
+		os.Exit(exitInterrupted)
+	}()
+
+	return s
+}
+
+// received returns the signal that stopped the run, or nil.
+func (s *shutdown) received() os.Signal {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.signal
+}
+
+// stop is called once the run has wound down. Signals received from now on
+// no longer force the exit, so cleanup can't be cut short.
+func (s *shutdown) stop() {
+	close(s.done)
+}
+
+// cleanupCommands returns the commands given with --on-interrupt. Like
+// stringArray, except that commands from the environment are separated by
+// newlines as they may contain spaces.
+func cleanupCommands() []string {
+	flag := pflag.Lookup("on-interrupt")
+	if flag != nil && flag.Changed {
+		commands, _ := pflag.CommandLine.GetStringArray("on-interrupt")
+		return commands
+	}
+
+	s, ok := viper.Get("on-interrupt").(string)
+	if !ok {
+		return viper.GetStringSlice("on-interrupt")
+	}
+	if flag != nil && s == flag.DefValue {
+		return nil
+	}
+
+	var commands []string
+	for _, c := range strings.Split(s, "\n") {
+		if c = strings.TrimSpace(c); c != "" {
+			commands = append(commands, c)
+		}
+	}
+	return commands
+}
+
+// runCleanup runs each command with sh, in order, within timeout. The exit
+// code, decision and signal are passed in the environment. Failures are
+// logged and don't stop the remaining commands.
+func runCleanup(commands []string, timeout time.Duration, res waitResult, sig os.Signal) {
+	if len(commands) == 0 {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	env := append(os.Environ(),
+		"BUILD_WAITER_EXIT_CODE="+strconv.Itoa(res.ExitCode),
+		"BUILD_WAITER_DECISION="+res.Decision,
+	)
+	if sig != nil {
+		env = append(env, "BUILD_WAITER_SIGNAL="+sig.String())
+	}
+
+	for _, c := range commands {
+		logInfo(eventCleanup, fields{"command": c}, "Running cleanup command %q", c)
+
+		cmd := exec.CommandContext(ctx, "sh", "-c", c)
+		cmd.Env = env
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		if err := cmd.Run(); err != nil {
+			logWarn(eventCleanup, fields{"command": c, "error": err.Error()}, "Cleanup command %q failed: %v", c, err)
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"context"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestHandleSignals(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s := handleSignals(cancel, func() time.Duration { return time.Minute })
+	defer s.stop()
+
+	require.NoError(t, syscall.Kill(os.Getpid(), syscall.SIGHUP))
+
+	select {
+	case <-ctx.Done():
+	case <-time.After(5 * time.Second):
+		t.Fatal("context not cancelled")
+	}
+	assert.Equal(t, syscall.SIGHUP, s.received())
+}
+
+func TestRunCleanup(t *testing.T) {
+	dir, err := ioutil.TempDir("", "build-waiter")
+	require.NoError(t, err)
+	defer os.RemoveAll(dir)
+
+	out := filepath.Join(dir, "cleanup")
+	commands := []string{
+		"exit 1",
+		`echo "$BUILD_WAITER_EXIT_CODE $BUILD_WAITER_DECISION $BUILD_WAITER_SIGNAL" > ` + out,
+	}
+	res := waitResult{Decision: decisionInterrupted, ExitCode: exitInterrupted}
+
+	runCleanup(commands, time.Minute, res, syscall.SIGTERM)
+
+	content, err := ioutil.ReadFile(out)
+	require.NoError(t, err)
+	assert.Equal(t, "130 interrupted terminated\n", string(content))
+}
+
+func TestInterruptedError(t *testing.T) {
+	assert.Equal(t, "interrupted", interruptedError{}.Error())
+	assert.Equal(t, "interrupted by terminated", interruptedError{signal: syscall.SIGTERM}.Error())
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"fmt"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+// buildState is the waiter's view of a Codeship build status.
+type buildState int
+
+const (
+	stateUnknown buildState = iota
+	stateQueued
+	stateRunning
+	stateSucceeded
+	stateFailed
+	stateCancelled
+)
+
+var buildStateNames = map[buildState]string{
+	stateUnknown:   "unknown",
+	stateQueued:    "queued",
+	stateRunning:   "running",
+	stateSucceeded: "succeeded",
+	stateFailed:    "failed",
+	stateCancelled: "cancelled",
+}
+
+// codeshipStatuses maps every known Codeship build and step status to its
+// state.
+var codeshipStatuses = map[string]buildState{
+	"initiated":              stateQueued,
+	"waiting":                stateQueued,
+	"blocked":                stateQueued,
+	"testing":                stateRunning,
+	"success":                stateSucceeded,
+	"error":                  stateFailed,
+	"infrastructure_failure": stateFailed,
+	"stopped":                stateCancelled,
+	"ignored":                stateCancelled,
+
+	// statuses only used by build steps
+	"running": stateRunning,
+	"skipped": stateCancelled,
+}
+
+func (s buildState) String() string {
+	return buildStateNames[s]
+}
+
+// active reports whether a build in this state is still ahead of us in the
+// queue, either waiting to start or running.
+func (s buildState) active() bool {
+	return s == stateQueued || s == stateRunning
+}
+
+// parseBuildState parses the name of a known state, as used for
+// --unknown-status.
+func parseBuildState(name string) (buildState, error) {
+	for s, n := range buildStateNames {
+		if n == name && s != stateUnknown {
+			return s, nil
+		}
+	}
+	return stateUnknown, fmt.Errorf("invalid build state %q: must be queued, running, succeeded, failed or cancelled", name)
+}
+
+// classifyStatus returns the state for a Codeship status, or stateUnknown if
+// the status isn't one we know about.
+func classifyStatus(status string) buildState {
+	return codeshipStatuses[status]
+}
+
+// state classifies b, mapping unknown statuses to the configured default.
+func (m monitor) state(b codeship.Build) buildState {
+	s := classifyStatus(b.Status)
+	if s == stateUnknown {
+		return m.unknownState
+	}
+	return s
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"context"
+	"testing"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestClassifyStatus(t *testing.T) {
+	testCases := []struct {
+		status string
+		state  buildState
+	}{
+		{status: "initiated", state: stateQueued},
+		{status: "waiting", state: stateQueued},
+		{status: "blocked", state: stateQueued},
+		{status: "testing", state: stateRunning},
+		{status: "success", state: stateSucceeded},
+		{status: "error", state: stateFailed},
+		{status: "infrastructure_failure", state: stateFailed},
+		{status: "stopped", state: stateCancelled},
+		{status: "ignored", state: stateCancelled},
+		{status: "something_new", state: stateUnknown},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.status, func(t *testing.T) {
+			assert.Equal(t, tc.state, classifyStatus(tc.status))
+		})
+	}
+}
+
+func TestParseBuildState(t *testing.T) {
+	state, err := parseBuildState("queued")
+	require.NoError(t, err)
+	assert.Equal(t, stateQueued, state)
+
+	_, err = parseBuildState("unknown")
+	require.Error(t, err)
+
+	_, err = parseBuildState("bogus")
+	require.Error(t, err)
+}
+
+func TestMonitorState(t *testing.T) {
+	monitor := &monitor{
+		unknownState: stateRunning,
+	}
+
+	assert.Equal(t, stateSucceeded, monitor.state(codeship.Build{Status: "success"}))
+	assert.Equal(t, stateRunning, monitor.state(codeship.Build{Status: "something_new"}))
+}
+
+func TestBuildsToWatchIncludesQueued(t *testing.T) {
+	monitor := &monitor{
+		buildGetter: mockBuildList{
+			builds: []codeship.Build{
+				{UUID: "1", Status: "testing", Branch: "test-branch"},
+				{UUID: "2", Status: "waiting", Branch: "test-branch"},
+				{UUID: "3", Status: "initiated", Branch: "test-branch"},
+				{UUID: "4", Status: "success", Branch: "test-branch"},
+				{UUID: "5", Status: "error", Branch: "test-branch"},
+			},
+		},
+	}
+
+	builds, err := monitor.buildsToWatch(context.TODO(), "project-id", "test-branch")
+	require.NoError(t, err)
+	assert.Len(t, builds, 3)
+}
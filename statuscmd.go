@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/spf13/viper"
+)
+
+const commandStatus = "status"
+
+type outputFormat string
+
+const (
+	outputTable outputFormat = "table"
+	outputJSON  outputFormat = "json"
+)
+
+// queueEntry describes a build in the queue as printed by status.
+type queueEntry struct {
+	Position       int    `json:"position"`
+	UUID           string `json:"uuid"`
+	ProjectUUID    string `json:"project_uuid"`
+	Branch         string `json:"branch"`
+	CommitSha      string `json:"commit_sha"`
+	Username       string `json:"username"`
+	Status         string `json:"status"`
+	ElapsedSeconds int64  `json:"elapsed_seconds"`
+	HoldsGate      bool   `json:"holds_gate"`
+}
+
+// runStatus prints the queue for a branch, or for a lock, so it's easy to
+// see why a build is stuck.
+func runStatus(ctx context.Context, m monitor) error {
+	format := outputFormat(viper.GetString("output"))
+	switch format {
+	case outputTable, outputJSON:
+	default:
+		return configErrorf("invalid --output %q: must be table or json", format)
+	}
+
+	projectUUID, err := commandProject()
+	if err != nil {
+		return err
+	}
+
+	branch, err := commandBranch(ctx, m, projectUUID)
+	if err != nil {
+		return err
+	}
+
+	// show the gate as the branch's policy enforces it
+	m = m.withPolicy(branch)
+
+	builds, err := m.queuedBuilds(ctx, projectUUID, branch)
+	if err != nil {
+		return err
+	}
+
+	return printQueue(os.Stdout, m.queueEntries(builds, time.Now()), format)
+}
+
+// commandBranch returns the branch given with --branch, falling back to the
+// branch of the running build.
+func commandBranch(ctx context.Context, m monitor, projectUUID string) (string, error) {
+	if branch := viper.GetString("branch"); branch != "" {
+		return branch, nil
+	}
+
+	buildUUID := viper.GetString("build_id")
+	if buildUUID == "" {
+		return "", configError("--branch or CI_BUILD_ID required")
+	}
+
+	build, err := m.getBuild(ctx, projectUUID, buildUUID)
+	if err != nil {
+		return "", err
+	}
+	return build.Branch, nil
+}
+
+// queueEntries describes builds, ordered oldest first. The oldest builds,
+// as many as may run at once, hold the gate.
+func (m monitor) queueEntries(builds []codeship.Build, now time.Time) []queueEntry {
+	entries := make([]queueEntry, 0, len(builds))
+	for i, b := range builds {
+		entries = append(entries, queueEntry{
+			Position:       i + 1,
+			UUID:           b.UUID,
+			ProjectUUID:    b.ProjectUUID,
+			Branch:         b.Branch,
+			CommitSha:      b.CommitSha,
+			Username:       b.Username,
+			Status:         b.Status,
+			ElapsedSeconds: int64(elapsed(b, now) / time.Second),
+			HoldsGate:      i < m.slots(),
+		})
+	}
+	return entries
+}
+
+// elapsed returns how long a build has been running, or queued if it hasn't
+// been allocated yet.
+func elapsed(b codeship.Build, now time.Time) time.Duration {
+	start := b.AllocatedAt
+	if start.IsZero() {
+		start = b.QueuedAt
+	}
+	if start.IsZero() {
+		return 0
+	}
+	return now.Sub(start)
+}
+
+func printQueue(w io.Writer, entries []queueEntry, format outputFormat) error {
+	if format == outputJSON {
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(entries)
+	}
+
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "\tPOS\tBUILD\tCOMMIT\tAUTHOR\tSTATUS\tELAPSED")
+	for _, e := range entries {
+		marker := ""
+		if e.HoldsGate {
+			marker = "*"
+		}
+		fmt.Fprintf(tw, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n", marker, e.Position, e.UUID, shortSha(e.CommitSha), e.Username, e.Status, time.Duration(e.ElapsedSeconds)*time.Second)
+	}
+	return tw.Flush()
+}
+
+// shortSha abbreviates a commit SHA for display.
+func shortSha(sha string) string {
+	if len(sha) > 7 {
+		return sha[:7]
+	}
+	return sha
+}
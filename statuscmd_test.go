@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestQueueEntries(t *testing.T) {
+	now := time.Now()
+	builds := []codeship.Build{
+		{UUID: "1", CommitSha: "0123456789abcdef", Username: "alice", Status: "testing", AllocatedAt: now.Add(-5 * time.Minute), QueuedAt: now.Add(-6 * time.Minute)},
+		{UUID: "2", CommitSha: "fedcba9876543210", Username: "bob", Status: "waiting", QueuedAt: now.Add(-time.Minute)},
+	}
+
+	monitor := &monitor{}
+	entries := monitor.queueEntries(builds, now)
+
+	require.Len(t, entries, 2)
+	assert.Equal(t, 1, entries[0].Position)
+	assert.Equal(t, int64(300), entries[0].ElapsedSeconds)
+	assert.True(t, entries[0].HoldsGate)
+	assert.Equal(t, 2, entries[1].Position)
+	assert.Equal(t, int64(60), entries[1].ElapsedSeconds)
+	assert.False(t, entries[1].HoldsGate)
+}
+
+func TestQueueEntriesPolicy(t *testing.T) {
+	path, cleanup := writeConfigFile(t, "build-waiter.yml", testConfigFile)
+	defer cleanup()
+
+	config, err := readConfigFile(path)
+	require.NoError(t, err)
+
+	now := time.Now()
+	builds := []codeship.Build{
+		{UUID: "1", Status: "testing", Branch: "release/1.0", QueuedAt: now.Add(-3 * time.Minute)},
+		{UUID: "2", Status: "testing", Branch: "release/1.0", QueuedAt: now.Add(-2 * time.Minute)},
+		{UUID: "3", Status: "waiting", Branch: "release/1.0", QueuedAt: now.Add(-time.Minute)},
+	}
+
+	// release/* runs as a semaphore with two slots
+	monitor := monitor{config: config, maxConcurrent: 1}
+	entries := monitor.withPolicy("release/1.0").queueEntries(builds, now)
+
+	require.Len(t, entries, 3)
+	assert.True(t, entries[0].HoldsGate)
+	assert.True(t, entries[1].HoldsGate)
+	assert.False(t, entries[2].HoldsGate)
+}
+
+func TestPrintQueue(t *testing.T) {
+	entries := []queueEntry{
+		{Position: 1, UUID: "1", CommitSha: "0123456789abcdef", Username: "alice", Status: "testing", ElapsedSeconds: 300, HoldsGate: true},
+		{Position: 2, UUID: "2", CommitSha: "fedcba9876543210", Username: "bob", Status: "waiting", ElapsedSeconds: 60},
+	}
+
+	t.Run("table", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, printQueue(&buf, entries, outputTable))
+
+		lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+		require.Len(t, lines, 3)
+		assert.Contains(t, lines[0], "BUILD")
+		assert.True(t, strings.HasPrefix(lines[1], "*"))
+		assert.Contains(t, lines[1], "0123456")
+		assert.Contains(t, lines[1], "5m0s")
+		assert.False(t, strings.HasPrefix(lines[2], "*"))
+	})
+
+	t.Run("json", func(t *testing.T) {
+		var buf bytes.Buffer
+		require.NoError(t, printQueue(&buf, entries, outputJSON))
+
+		var decoded []queueEntry
+		require.NoError(t, json.Unmarshal(buf.Bytes(), &decoded))
+		assert.Equal(t, entries, decoded)
+	})
+}
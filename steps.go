@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+type stepLister interface {
+	ListBuildSteps(ctx context.Context, projectUUID, buildUUID string, opts ...codeship.PaginationOption) (codeship.BuildSteps, codeship.Response, error)
+}
+
+// stepsFinished reports whether every gated step of a running build has
+// finished. A step that hasn't shown up yet hasn't finished.
+func (m monitor) stepsFinished(ctx context.Context, b codeship.Build) (bool, error) {
+	steps, err := m.listSteps(ctx, b)
+	if err != nil {
+		return false, err
+	}
+
+	for _, name := range m.steps {
+		step, ok := findStep(steps, name)
+		if !ok || m.stepState(step).active() {
+			return false, nil
+		}
+	}
+
+	return true, nil
+}
+
+// stepState classifies a step status like a build status.
+func (m monitor) stepState(step codeship.BuildStep) buildState {
+	return m.state(codeship.Build{Status: step.Status})
+}
+
+// findStep searches steps, and the steps nested in them, for a step called
+// name.
+func findStep(steps []codeship.BuildStep, name string) (codeship.BuildStep, bool) {
+	for _, s := range steps {
+		if s.Name == name {
+			return s, true
+		}
+		if nested, ok := findStep(s.Steps, name); ok {
+			return nested, true
+		}
+	}
+	return codeship.BuildStep{}, false
+}
+
+// listSteps returns all steps of a build, following pagination.
+func (m monitor) listSteps(ctx context.Context, b codeship.Build) ([]codeship.BuildStep, error) {
+	var (
+		all  []codeship.BuildStep
+		opts []codeship.PaginationOption
+	)
+
+	for {
+		var (
+			steps codeship.BuildSteps
+			resp  codeship.Response
+		)
+		err := m.retry(ctx, "list steps of build "+b.UUID, func() (codeship.Response, error) {
+			var err error
+			steps, resp, err = m.ListBuildSteps(ctx, b.ProjectUUID, b.UUID, opts...)
+			return resp, err
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		all = append(all, steps.Steps...)
+
+		if resp.IsLastPage() || resp.Next == "" {
+			return all, nil
+		}
+
+		next, err := resp.NextPage()
+		if err != nil {
+			return nil, err
+		}
+		opts = []codeship.PaginationOption{codeship.Page(next), codeship.PerPage(50)}
+	}
+}
@@ -0,0 +1,119 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+type mockStepLister map[string][]codeship.BuildStep
+
+func (m mockStepLister) ListBuildSteps(ctx context.Context, projectUUID, buildUUID string, opts ...codeship.PaginationOption) (codeship.BuildSteps, codeship.Response, error) {
+	return codeship.BuildSteps{
+		Steps: m[buildUUID],
+	}, codeship.Response{}, nil
+}
+
+func TestFindStep(t *testing.T) {
+	steps := []codeship.BuildStep{
+		{Name: "tests", Status: "success"},
+		{Name: "deploy", Status: "running", Steps: []codeship.BuildStep{
+			{Name: "deploy-staging", Status: "success"},
+		}},
+	}
+
+	step, ok := findStep(steps, "deploy-staging")
+	require.True(t, ok)
+	assert.Equal(t, "success", step.Status)
+
+	_, ok = findStep(steps, "deploy-production")
+	assert.False(t, ok)
+}
+
+func TestPassedGate(t *testing.T) {
+	testCases := []struct {
+		name   string
+		status string
+		steps  []codeship.BuildStep
+		passed bool
+	}{
+		{
+			name:   "build finished",
+			status: "success",
+			passed: true,
+		}, {
+			name:   "step finished",
+			status: "testing",
+			steps: []codeship.BuildStep{
+				{Name: "deploy-staging", Status: "success"},
+				{Name: "smoke-tests", Status: "running"},
+			},
+			passed: true,
+		}, {
+			name:   "step running",
+			status: "testing",
+			steps: []codeship.BuildStep{
+				{Name: "deploy-staging", Status: "running"},
+			},
+		}, {
+			name:   "step not started",
+			status: "testing",
+			steps: []codeship.BuildStep{
+				{Name: "tests", Status: "running"},
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				buildGetter: mockBuildGetter{
+					buildStatus: tc.status,
+				},
+				stepLister: mockStepLister{
+					"build-uuid": tc.steps,
+				},
+				projectGetter: mockProjectGetter{
+					projectType: codeship.ProjectTypePro,
+				},
+				steps: []string{"deploy-staging"},
+			}
+
+			passed, err := monitor.passedGate(context.TODO(), codeship.Build{UUID: "build-uuid"})
+			require.NoError(t, err)
+			assert.Equal(t, tc.passed, passed)
+		})
+	}
+}
+
+func TestWaitOnPreviousBuildsSteps(t *testing.T) {
+	now := time.Now()
+	monitor := &monitor{
+		buildGetter: mockBuildList{
+			builds: []codeship.Build{
+				{UUID: "ahead", Status: "testing", Branch: "test-branch", QueuedAt: now.Add(-time.Minute)},
+				{UUID: "self", Status: "testing", Branch: "test-branch", QueuedAt: now},
+			},
+		},
+		stepLister: mockStepLister{
+			"ahead": {
+				{Name: "deploy-staging", Status: "success"},
+				{Name: "smoke-tests", Status: "running"},
+			},
+		},
+		projectGetter: mockProjectGetter{
+			projectType: codeship.ProjectTypePro,
+		},
+		order:     orderQueued,
+		steps:     []string{"deploy-staging"},
+		maxWait:   10 * time.Millisecond,
+		onTimeout: timeoutFail,
+	}
+
+	err := monitor.waitOnPreviousBuilds(context.TODO(), "project-uuid", "self", "test-branch")
+	require.NoError(t, err)
+}
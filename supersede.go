@@ -0,0 +1,103 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"path"
+
+	codeship "github.com/codeship/codeship-go"
+)
+
+type newerBuildPolicy string
+
+const (
+	newerIgnore newerBuildPolicy = "ignore"
+	newerStop   newerBuildPolicy = "stop"
+	newerExit   newerBuildPolicy = "exit"
+)
+
+// supersededError is returned when a newer build for a different commit
+// appeared on the branch while we were waiting.
+type supersededError struct {
+	newer codeship.Build
+}
+
+func (e supersededError) Error() string {
+	return fmt.Sprintf("superseded by newer build %s for commit %s", e.newer.UUID, e.newer.CommitSha)
+}
+
+// branchProtected reports whether branch matches any of the protected branch
+// names or glob patterns.
+func branchProtected(branch string, protected []string) bool {
+	for _, pattern := range protected {
+		if pattern == branch {
+			return true
+		}
+		if matched, err := path.Match(pattern, branch); err == nil && matched {
+			return true
+		}
+	}
+	return false
+}
+
+// supersedePreviousBuilds stops every running build on the branch that is
+// ahead of ours instead of waiting on it. Builds of other projects sharing a
+// lock with us and of other branches sharing a branch group with ours are
+// never stopped, they are waited on instead.
+func (m monitor) supersedePreviousBuilds(ctx context.Context, projectUUID, buildUUID, branch string) error {
+	q, err := m.queue(ctx, projectUUID, buildUUID, branch)
+	if err != nil {
+		return err
+	}
+
+	var skipped bool
+	for _, b := range q.ahead {
+		if b.ProjectUUID != q.self.ProjectUUID || b.Branch != q.self.Branch {
+			skipped = true
+			continue
+		}
+		if err := m.stopBuild(ctx, b, "superseded by build "+buildUUID); err != nil {
+			return err
+		}
+		m.superseded = append(m.superseded, b.UUID)
+	}
+
+	if skipped {
+		return m.waitOnPreviousBuilds(ctx, projectUUID, buildUUID, branch)
+	}
+
+	logInfo(eventResumed, fields{"build_uuid": buildUUID, "branch": branch}, "Resuming build")
+	return nil
+}
+
+// newerBuild returns the first of builds of our project and branch that was
+// allocated after self for a different commit.
+func newerBuild(self codeship.Build, builds []codeship.Build) (codeship.Build, bool) {
+	for _, b := range builds {
+		if b.UUID == self.UUID || b.CommitSha == self.CommitSha || b.ProjectUUID != self.ProjectUUID || b.Branch != self.Branch {
+			continue
+		}
+		if b.AllocatedAt.After(self.AllocatedAt) {
+			return b, true
+		}
+	}
+	return codeship.Build{}, false
+}
+
+// checkSuperseded looks for a newer build behind ours in the queue and, if
+// there is one, applies the newer build policy. It returns a supersededError
+// when we should stop waiting.
+func (m monitor) checkSuperseded(ctx context.Context, q buildQueue) error {
+	newer, ok := newerBuild(q.self, q.behind)
+	if !ok {
+		return nil
+	}
+
+	if m.onNewer == newerStop {
+		if err := m.stopBuild(ctx, q.self, "superseded by newer build "+newer.UUID); err != nil {
+			return err
+		}
+	}
+
+	return supersededError{newer: newer}
+}
@@ -0,0 +1,209 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestBranchProtected(t *testing.T) {
+	testCases := []struct {
+		name      string
+		branch    string
+		protected []string
+		expected  bool
+	}{
+		{
+			name:      "exact match",
+			branch:    "master",
+			protected: []string{"master"},
+			expected:  true,
+		}, {
+			name:      "glob match",
+			branch:    "release/1.0",
+			protected: []string{"master", "release/*"},
+			expected:  true,
+		}, {
+			name:      "no match",
+			branch:    "feature/foo",
+			protected: []string{"master", "release/*"},
+			expected:  false,
+		}, {
+			name:     "nothing protected",
+			branch:   "master",
+			expected: false,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, branchProtected(tc.branch, tc.protected))
+		})
+	}
+}
+
+func TestSupersedePreviousBuilds(t *testing.T) {
+	testCases := []struct {
+		name      string
+		buildUUID string
+		dryRun    bool
+		stopped   []string
+	}{
+		{
+			name:      "stops older builds",
+			buildUUID: "2",
+			stopped:   []string{"1"},
+		}, {
+			name:      "dry run",
+			buildUUID: "2",
+			dryRun:    true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stopper := &mockBuildStopper{}
+			monitor := &monitor{
+				buildGetter:  mockBuildGetter{},
+				buildStopper: stopper,
+				dryRun:       tc.dryRun,
+			}
+
+			err := monitor.supersedePreviousBuilds(context.TODO(), "project-uuid", tc.buildUUID, "test-branch")
+			require.NoError(t, err)
+			assert.Equal(t, tc.stopped, stopper.stopped)
+		})
+	}
+}
+
+func TestSupersedePreviousBuildsBranchGroup(t *testing.T) {
+	now := time.Now()
+	builds := mockBuildList{
+		builds: []codeship.Build{
+			{UUID: "main-1", Status: "testing", Branch: "main", QueuedAt: now.Add(-2 * time.Minute)},
+			{UUID: "hotfix-1", Status: "testing", Branch: "hotfix/x", QueuedAt: now.Add(-time.Minute)},
+			{UUID: "self", Status: "testing", Branch: "main", QueuedAt: now},
+		},
+	}
+
+	groups, err := parseBranchGroups([]string{"main,hotfix/*"})
+	require.NoError(t, err)
+
+	stopper := &mockBuildStopper{}
+	monitor := &monitor{
+		buildGetter:  builds,
+		buildStopper: stopper,
+		order:        orderQueued,
+		branchGroups: groups,
+		maxWait:      10 * time.Millisecond,
+		onTimeout:    timeoutFail,
+	}
+
+	// the hotfix build isn't stopped but still holds us back
+	err = monitor.supersedePreviousBuilds(context.TODO(), "project-uuid", "self", "main")
+	timeoutErr, ok := err.(timeoutError)
+	require.True(t, ok)
+	assert.Equal(t, "hotfix-1", timeoutErr.build.UUID)
+	assert.Equal(t, []string{"main-1"}, stopper.stopped)
+}
+
+func TestNewerBuild(t *testing.T) {
+	now := time.Now()
+	self := codeship.Build{UUID: "self", CommitSha: "abc", AllocatedAt: now}
+
+	testCases := []struct {
+		name     string
+		builds   []codeship.Build
+		expected string
+		found    bool
+	}{
+		{
+			name: "newer build for another commit",
+			builds: []codeship.Build{
+				{UUID: "older", CommitSha: "def", AllocatedAt: now.Add(-time.Minute)},
+				self,
+				{UUID: "newer", CommitSha: "ghi", AllocatedAt: now.Add(time.Minute)},
+			},
+			expected: "newer",
+			found:    true,
+		}, {
+			name: "newer build for the same commit",
+			builds: []codeship.Build{
+				self,
+				{UUID: "restarted", CommitSha: "abc", AllocatedAt: now.Add(time.Minute)},
+			},
+		}, {
+			name: "newer build on another branch",
+			builds: []codeship.Build{
+				self,
+				{UUID: "hotfix", CommitSha: "def", Branch: "hotfix/x", AllocatedAt: now.Add(time.Minute)},
+			},
+		}, {
+			name: "only older builds",
+			builds: []codeship.Build{
+				{UUID: "older", CommitSha: "def", AllocatedAt: now.Add(-time.Minute)},
+				self,
+			},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			newer, found := newerBuild(self, tc.builds)
+			assert.Equal(t, tc.found, found)
+			assert.Equal(t, tc.expected, newer.UUID)
+		})
+	}
+}
+
+func TestCheckSuperseded(t *testing.T) {
+	testCases := []struct {
+		name    string
+		onNewer newerBuildPolicy
+		stopped []string
+	}{
+		{
+			name:    "exit",
+			onNewer: newerExit,
+		}, {
+			name:    "stop",
+			onNewer: newerStop,
+			stopped: []string{"self"},
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			stopper := &mockBuildStopper{}
+			monitor := &monitor{
+				buildGetter:  mockBuildGetter{},
+				buildStopper: stopper,
+				onNewer:      tc.onNewer,
+			}
+
+			now := time.Now()
+			q := buildQueue{
+				self: codeship.Build{
+					UUID:        "self",
+					Branch:      "test-branch",
+					CommitSha:   "abc",
+					AllocatedAt: now.Add(-3 * time.Minute),
+				},
+				behind: []codeship.Build{
+					{UUID: "2", Branch: "test-branch", CommitSha: "def", AllocatedAt: now.Add(-time.Minute)},
+				},
+			}
+
+			err := monitor.checkSuperseded(context.TODO(), q)
+			require.Error(t, err)
+			supersededErr, ok := err.(supersededError)
+			require.True(t, ok)
+			assert.Equal(t, "2", supersededErr.newer.UUID)
+			assert.Equal(t, tc.stopped, stopper.stopped)
+		})
+	}
+}
@@ -0,0 +1,149 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/spf13/viper"
+)
+
+const commandWaitFor = "wait-for"
+
+// buildFailedError is returned when a build we waited on did not succeed.
+type buildFailedError struct {
+	build codeship.Build
+}
+
+func (e buildFailedError) Error() string {
+	return fmt.Sprintf("build %s for commit %s finished with status %s", e.build.UUID, e.build.CommitSha, e.build.Status)
+}
+
+// runWaitFor blocks until a given build has finished and fails unless it
+// succeeded. The build is given by UUID or by commit SHA and branch.
+func runWaitFor(ctx context.Context, m monitor) error {
+	projectUUID, err := commandProject()
+	if err != nil {
+		return err
+	}
+
+	if branch := viper.GetString("branch"); branch != "" {
+		m = m.withPolicy(branch)
+	}
+
+	buildUUID := viper.GetString("build")
+	if buildUUID == "" {
+		commit := viper.GetString("commit")
+		branch := viper.GetString("branch")
+		if commit == "" || branch == "" {
+			return configError("--build, or --commit and --branch, required")
+		}
+
+		build, found, err := m.findBuild(ctx, projectUUID, branch, commit, time.Time{})
+		if err != nil {
+			return err
+		}
+		if !found {
+			return fmt.Errorf("no build found for commit %s on branch %s", commit, branch)
+		}
+		buildUUID = build.UUID
+	}
+
+	build, err := m.waitForBuild(ctx, projectUUID, buildUUID)
+	if err != nil {
+		return err
+	}
+
+	if m.state(build) != stateSucceeded {
+		return buildFailedError{build: build}
+	}
+
+	logInfo(eventBuildFinished, fields{"build_uuid": build.UUID, "commit_sha": build.CommitSha, "status": build.Status}, "Build %s succeeded", build.UUID)
+	return nil
+}
+
+// commandProject returns the project given with --project, falling back to
+// the project of the running build.
+func commandProject() (string, error) {
+	projectUUID := viper.GetString("project")
+	if projectUUID == "" {
+		projectUUID = viper.GetString("project_id")
+	}
+	if projectUUID == "" {
+		return "", configError("--project or CI_PROJECT_ID required")
+	}
+	return projectUUID, nil
+}
+
+// waitForBuild polls a build until it is no longer queued or running and
+// returns it.
+func (m monitor) waitForBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, error) {
+	start := time.Now()
+
+	var timeout <-chan time.Time
+	if m.maxWait > 0 {
+		timer := time.NewTimer(m.maxWait)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+
+	poll := m.poll
+	for {
+		build, err := m.getBuild(ctx, projectUUID, buildUUID)
+		if err != nil {
+			return codeship.Build{}, err
+		}
+		if !m.state(build).active() {
+			return build, nil
+		}
+
+		logInfo(eventWaiting, fields{"build_uuid": buildUUID, "elapsed_seconds": elapsedSeconds(start)}, "Waiting on build %s", buildUUID)
+
+		select {
+		case <-ctx.Done():
+			return build, interruptedError{}
+		case <-timeout:
+			return build, timeoutError{maxWait: m.maxWait, build: build}
+		case <-time.After(poll.next()):
+		}
+	}
+}
+
+// findBuild returns the newest build on branch, or on any branch if branch
+// is empty, for commit, which may be abbreviated. Builds are listed newest
+// first, so with since set it stops paging once it reaches builds queued
+// before since instead of going through the whole history.
+func (m monitor) findBuild(ctx context.Context, projectUUID, branch, commit string, since time.Time) (codeship.Build, bool, error) {
+	builds, resp, err := m.listBuilds(ctx, projectUUID)
+	if err != nil {
+		return codeship.Build{}, false, err
+	}
+
+	for {
+		older := false
+		for _, b := range builds.Builds {
+			if (branch == "" || b.Branch == branch) && b.CommitSha != "" && strings.HasPrefix(b.CommitSha, commit) {
+				return b, true, nil
+			}
+			if !b.QueuedAt.IsZero() && b.QueuedAt.Before(since) {
+				older = true
+			}
+		}
+
+		if older || resp.IsLastPage() || resp.Next == "" {
+			return codeship.Build{}, false, nil
+		}
+
+		next, err := resp.NextPage()
+		if err != nil {
+			return codeship.Build{}, false, err
+		}
+
+		builds, resp, err = m.listBuilds(ctx, projectUUID, codeship.Page(next), codeship.PerPage(50))
+		if err != nil {
+			return codeship.Build{}, false, err
+		}
+	}
+}
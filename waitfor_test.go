@@ -0,0 +1,143 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestWaitForBuild(t *testing.T) {
+	testCases := []struct {
+		name    string
+		status  string
+		timeout bool
+	}{
+		{
+			name:   "finished",
+			status: "success",
+		}, {
+			name:   "failed",
+			status: "error",
+		}, {
+			name:    "still running",
+			status:  "testing",
+			timeout: true,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			monitor := &monitor{
+				buildGetter: mockBuildGetter{
+					buildStatus: tc.status,
+				},
+				maxWait: 10 * time.Millisecond,
+			}
+
+			build, err := monitor.waitForBuild(context.TODO(), "project-uuid", "build-uuid")
+			if tc.timeout {
+				_, ok := err.(timeoutError)
+				require.True(t, ok)
+			} else {
+				require.NoError(t, err)
+			}
+			assert.Equal(t, tc.status, build.Status)
+		})
+	}
+}
+
+func TestFindBuild(t *testing.T) {
+	monitor := &monitor{
+		buildGetter: mockBuildList{
+			builds: []codeship.Build{
+				{UUID: "3", Branch: "other", CommitSha: "abc123"},
+				{UUID: "2", Branch: "master", CommitSha: "abc123"},
+				{UUID: "1", Branch: "master", CommitSha: "abc123"},
+			},
+		},
+	}
+
+	build, found, err := monitor.findBuild(context.TODO(), "project-uuid", "master", "abc", time.Time{})
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "2", build.UUID)
+
+	_, found, err = monitor.findBuild(context.TODO(), "project-uuid", "master", "def", time.Time{})
+	require.NoError(t, err)
+	assert.False(t, found)
+
+	build, found, err = monitor.findBuild(context.TODO(), "project-uuid", "", "abc", time.Time{})
+	require.NoError(t, err)
+	require.True(t, found)
+	assert.Equal(t, "3", build.UUID)
+}
+
+// mockBuildPages serves one page of builds per ListBuilds call, newest first.
+type mockBuildPages struct {
+	pages [][]codeship.Build
+	calls int
+}
+
+func (m *mockBuildPages) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	page := m.calls
+	m.calls++
+
+	var resp codeship.Response
+	if page < len(m.pages)-1 {
+		resp.Links = codeship.Links{
+			Next: fmt.Sprintf("https://api.codeship.com/v2/builds?page=%d", page+2),
+			Last: fmt.Sprintf("https://api.codeship.com/v2/builds?page=%d", len(m.pages)),
+		}
+	}
+	return codeship.BuildList{Builds: m.pages[page]}, resp, nil
+}
+
+func (m *mockBuildPages) GetBuild(ctx context.Context, projectUUID, buildUUID string) (codeship.Build, codeship.Response, error) {
+	return codeship.Build{}, codeship.Response{}, codeship.ErrNotFound{}
+}
+
+func TestFindBuildSince(t *testing.T) {
+	now := time.Now()
+	pages := [][]codeship.Build{
+		{{UUID: "3", Branch: "master", CommitSha: "def456", QueuedAt: now.Add(-30 * time.Second)}},
+		{{UUID: "2", Branch: "master", CommitSha: "def456", QueuedAt: now.Add(-2 * time.Hour)}},
+		{{UUID: "1", Branch: "master", CommitSha: "abc123", QueuedAt: now.Add(-3 * time.Hour)}},
+	}
+
+	testCases := []struct {
+		name  string
+		since time.Time
+		found bool
+		calls int
+	}{
+		{
+			name:  "whole history",
+			found: true,
+			calls: 3,
+		}, {
+			name:  "since creation",
+			since: now.Add(-time.Minute),
+			calls: 2,
+		},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			builds := &mockBuildPages{pages: pages}
+			monitor := &monitor{buildGetter: builds}
+
+			build, found, err := monitor.findBuild(context.TODO(), "project-uuid", "master", "abc", tc.since)
+			require.NoError(t, err)
+			assert.Equal(t, tc.found, found)
+			if tc.found {
+				assert.Equal(t, "1", build.UUID)
+			}
+			assert.Equal(t, tc.calls, builds.calls)
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/spf13/viper"
+)
+
+const commandWatch = "watch"
+
+// progressWidth is the width of the progress bars drawn by watch.
+const progressWidth = 20
+
+type buildRestarter interface {
+	RestartBuild(ctx context.Context, projectUUID, buildUUID string) (bool, codeship.Response, error)
+}
+
+// stateColors are the ANSI colours statuses are drawn in.
+var stateColors = map[buildState]string{
+	stateQueued:    "\033[33m",
+	stateRunning:   "\033[36m",
+	stateSucceeded: "\033[32m",
+	stateFailed:    "\033[31m",
+	stateCancelled: "\033[90m",
+}
+
+const colorReset = "\033[0m"
+
+// runWatch keeps showing the queue for a branch, or a lock, until
+// interrupted. On a terminal it redraws the screen and lets the selected
+// build be stopped or restarted. Otherwise it prints the queue every
+// refresh.
+func runWatch(ctx context.Context, m monitor) error {
+	projectUUID, err := commandProject()
+	if err != nil {
+		return err
+	}
+
+	branch, err := commandBranch(ctx, m, projectUUID)
+	if err != nil {
+		return err
+	}
+
+	// show the gate as the branch's policy enforces it
+	m = m.withPolicy(branch)
+
+	refresh := viper.GetDuration("refresh")
+	if refresh <= 0 {
+		refresh = 5 * time.Second
+	}
+
+	typical, err := m.typicalDuration(ctx, projectUUID, branch)
+	if err != nil {
+		return err
+	}
+
+	if !isTerminal(os.Stdout) {
+		return m.watchPlain(ctx, os.Stdout, projectUUID, branch, refresh)
+	}
+
+	// without a terminal to type in there are no keys, just the redraws
+	var keys chan byte
+	if isTerminal(os.Stdin) {
+		restore := rawTerminal()
+		defer restore()
+
+		keys = make(chan byte)
+		go readKeys(os.Stdin, keys)
+	}
+
+	return m.watchTerminal(ctx, os.Stdout, keys, projectUUID, branch, refresh, typical)
+}
+
+// watchPlain prints the queue every refresh, for when stdout isn't a
+// terminal.
+func (m monitor) watchPlain(ctx context.Context, w io.Writer, projectUUID, branch string, refresh time.Duration) error {
+	for {
+		builds, err := m.queuedBuilds(ctx, projectUUID, branch)
+		if err != nil {
+			return err
+		}
+
+		now := time.Now()
+		fmt.Fprintf(w, "Queue for branch %s at %s\n", branch, now.Format(time.RFC3339))
+		if err := printQueue(w, m.queueEntries(builds, now), outputTable); err != nil {
+			return err
+		}
+		fmt.Fprintln(w)
+
+		select {
+		case <-ctx.Done():
+			return interruptedError{}
+		case <-time.After(refresh):
+		}
+	}
+}
+
+// watchTerminal redraws the queue every refresh and handles key presses
+// read from keys: j/k move the selection, s stops and r restarts the
+// selected build and q quits. Once keys is closed it keeps redrawing.
+func (m monitor) watchTerminal(ctx context.Context, w io.Writer, keys <-chan byte, projectUUID, branch string, refresh, typical time.Duration) error {
+	var (
+		selected int
+		message  string
+		builds   []codeship.Build
+	)
+
+	ticker := time.NewTicker(refresh)
+	defer ticker.Stop()
+
+	reload := true
+	for {
+		if reload {
+			var err error
+			builds, err = m.queuedBuilds(ctx, projectUUID, branch)
+			if err != nil {
+				return err
+			}
+			reload = false
+		}
+
+		if selected >= len(builds) {
+			selected = len(builds) - 1
+		}
+		if selected < 0 {
+			selected = 0
+		}
+
+		renderWatch(w, branch, m.queueEntries(builds, time.Now()), typical, selected, message, true)
+
+		select {
+		case <-ctx.Done():
+			return interruptedError{}
+		case <-ticker.C:
+			reload = true
+		case key, ok := <-keys:
+			if !ok {
+				// a nil channel is never ready, so only the redraws are left
+				keys = nil
+				continue
+			}
+			switch key {
+			case 'q':
+				return nil
+			case 'j':
+				selected++
+			case 'k':
+				selected--
+			case 's', 'r':
+				if len(builds) == 0 {
+					continue
+				}
+				message = m.watchAction(ctx, key, builds[selected])
+				reload = true
+			}
+		}
+	}
+}
+
+// watchAction stops or restarts b and returns a message describing the
+// outcome.
+func (m monitor) watchAction(ctx context.Context, key byte, b codeship.Build) string {
+	if key == 's' {
+		if err := m.stopBuild(ctx, b, "stopped from watch"); err != nil {
+			return fmt.Sprintf("Failed to stop build %s: %v", b.UUID, err)
+		}
+		return "Stopped build " + b.UUID
+	}
+
+	if err := m.restartBuild(ctx, b); err != nil {
+		return fmt.Sprintf("Failed to restart build %s: %v", b.UUID, err)
+	}
+	return "Restarted build " + b.UUID
+}
+
+// renderWatch draws a screen of the queue. The selected build is marked
+// with > and the builds holding the gate with *.
+func renderWatch(w io.Writer, branch string, entries []queueEntry, typical time.Duration, selected int, message string, color bool) {
+	if color {
+		fmt.Fprint(w, "\033[H\033[2J")
+	}
+
+	fmt.Fprintf(w, "Queue for branch %s, typical duration %s\n\n", branch, typical)
+
+	for i, e := range entries {
+		cursor := " "
+		if i == selected {
+			cursor = ">"
+		}
+		gate := " "
+		if e.HoldsGate {
+			gate = "*"
+		}
+
+		status := fmt.Sprintf("%-10s", e.Status)
+		if c, ok := stateColors[classifyStatus(e.Status)]; ok && color {
+			status = c + status + colorReset
+		}
+
+		elapsed := time.Duration(e.ElapsedSeconds) * time.Second
+		fmt.Fprintf(w, "%s%s %2d  %s  %-7s  %-12s  %s  %s %s\n", cursor, gate, e.Position, e.UUID, shortSha(e.CommitSha), e.Username, status, progressBar(elapsed, typical), elapsed)
+	}
+
+	if len(entries) == 0 {
+		fmt.Fprintln(w, "No builds in the queue")
+	}
+
+	fmt.Fprintln(w)
+	if message != "" {
+		fmt.Fprintln(w, message)
+	}
+	fmt.Fprintln(w, "j/k select  s stop  r restart  q quit")
+}
+
+// progressBar draws elapsed as a share of the typical build duration.
+func progressBar(elapsed, typical time.Duration) string {
+	if typical <= 0 {
+		return "[" + strings.Repeat("?", progressWidth) + "]"
+	}
+
+	filled := int(float64(progressWidth) * float64(elapsed) / float64(typical))
+	if filled > progressWidth {
+		filled = progressWidth
+	}
+	if filled < 0 {
+		filled = 0
+	}
+	return "[" + strings.Repeat("#", filled) + strings.Repeat(".", progressWidth-filled) + "]"
+}
+
+// typicalDuration returns the median duration of the recent successful
+// builds on the branch, or 0 if there are none.
+func (m monitor) typicalDuration(ctx context.Context, projectUUID, branch string) (time.Duration, error) {
+	builds, _, err := m.listBuilds(ctx, projectUUID)
+	if err != nil {
+		return 0, err
+	}
+
+	var durations []time.Duration
+	for _, b := range builds.Builds {
+		if b.Branch != branch || m.state(b) != stateSucceeded || b.AllocatedAt.IsZero() || b.FinishedAt.IsZero() {
+			continue
+		}
+		durations = append(durations, b.FinishedAt.Sub(b.AllocatedAt))
+	}
+
+	if len(durations) == 0 {
+		return 0, nil
+	}
+
+	sort.Slice(durations, func(i, j int) bool { return durations[i] < durations[j] })
+	return durations[len(durations)/2], nil
+}
+
+func (m monitor) restartBuild(ctx context.Context, b codeship.Build) error {
+	return m.retry(ctx, "restart build "+b.UUID, func() (codeship.Response, error) {
+		_, resp, err := m.RestartBuild(ctx, b.ProjectUUID, b.UUID)
+		return resp, err
+	})
+}
+
+// isTerminal reports whether f is a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// rawTerminal switches stdin to unbuffered input without echo so single key
+// presses can be read, and returns a function restoring the previous
+// settings. Without stty keys have to be followed by Enter.
+func rawTerminal() func() {
+	saved, err := stty("-g")
+	if err != nil {
+		return func() {}
+	}
+	if _, err := stty("-icanon", "-echo", "min", "1"); err != nil {
+		return func() {}
+	}
+	return func() {
+		_, _ = stty(strings.TrimSpace(saved))
+	}
+}
+
+func stty(args ...string) (string, error) {
+	cmd := exec.Command("stty", args...)
+	cmd.Stdin = os.Stdin
+	out, err := cmd.Output()
+	return string(out), err
+}
+
+// readKeys sends every byte read from r to keys and closes keys once r is
+// exhausted.
+func readKeys(r io.Reader, keys chan<- byte) {
+	defer close(keys)
+
+	br := bufio.NewReader(r)
+	for {
+		b, err := br.ReadByte()
+		if err != nil {
+			return
+		}
+		keys <- b
+	}
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	codeship "github.com/codeship/codeship-go"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestProgressBar(t *testing.T) {
+	testCases := []struct {
+		name     string
+		elapsed  time.Duration
+		typical  time.Duration
+		expected string
+	}{
+		{"unknown", time.Minute, 0, "[" + strings.Repeat("?", progressWidth) + "]"},
+		{"half", 5 * time.Minute, 10 * time.Minute, "[" + strings.Repeat("#", 10) + strings.Repeat(".", 10) + "]"},
+		{"overdue", time.Hour, 10 * time.Minute, "[" + strings.Repeat("#", progressWidth) + "]"},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.expected, progressBar(tc.elapsed, tc.typical))
+		})
+	}
+}
+
+func TestTypicalDuration(t *testing.T) {
+	now := time.Now()
+	builds := []codeship.Build{
+		{UUID: "1", Branch: "master", Status: "success", AllocatedAt: now.Add(-time.Hour), FinishedAt: now.Add(-50 * time.Minute)},
+		{UUID: "2", Branch: "master", Status: "success", AllocatedAt: now.Add(-time.Hour), FinishedAt: now.Add(-55 * time.Minute)},
+		{UUID: "3", Branch: "master", Status: "success", AllocatedAt: now.Add(-time.Hour), FinishedAt: now.Add(-40 * time.Minute)},
+		{UUID: "4", Branch: "master", Status: "error", AllocatedAt: now.Add(-time.Hour), FinishedAt: now.Add(-59 * time.Minute)},
+		{UUID: "5", Branch: "feature", Status: "success", AllocatedAt: now.Add(-time.Hour), FinishedAt: now},
+	}
+
+	monitor := &monitor{buildGetter: mockBuildList{builds: builds}}
+
+	typical, err := monitor.typicalDuration(context.Background(), "project", "master")
+	require.NoError(t, err)
+	assert.Equal(t, 10*time.Minute, typical)
+
+	typical, err = monitor.typicalDuration(context.Background(), "project", "develop")
+	require.NoError(t, err)
+	assert.Equal(t, time.Duration(0), typical)
+}
+
+type mockBuildRestarter struct {
+	restarted []string
+}
+
+func (m *mockBuildRestarter) RestartBuild(ctx context.Context, projectUUID, buildUUID string) (bool, codeship.Response, error) {
+	m.restarted = append(m.restarted, buildUUID)
+	return true, codeship.Response{}, nil
+}
+
+func TestWatchAction(t *testing.T) {
+	stopper := &mockBuildStopper{}
+	restarter := &mockBuildRestarter{}
+	monitor := &monitor{buildStopper: stopper, buildRestarter: restarter}
+
+	b := codeship.Build{UUID: "1", ProjectUUID: "project"}
+
+	assert.Equal(t, "Stopped build 1", monitor.watchAction(context.Background(), 's', b))
+	assert.Equal(t, []string{"1"}, stopper.stopped)
+
+	assert.Equal(t, "Restarted build 1", monitor.watchAction(context.Background(), 'r', b))
+	assert.Equal(t, []string{"1"}, restarter.restarted)
+}
+
+func TestRenderWatch(t *testing.T) {
+	entries := []queueEntry{
+		{Position: 1, UUID: "1", CommitSha: "0123456789abcdef", Username: "alice", Status: "testing", ElapsedSeconds: 300, HoldsGate: true},
+		{Position: 2, UUID: "2", CommitSha: "fedcba9876543210", Username: "bob", Status: "waiting", ElapsedSeconds: 60},
+	}
+
+	t.Run("plain", func(t *testing.T) {
+		var buf bytes.Buffer
+		renderWatch(&buf, "master", entries, 10*time.Minute, 1, "Stopped build 3", false)
+
+		out := buf.String()
+		assert.False(t, strings.Contains(out, "\033["))
+		assert.Contains(t, out, "typical duration 10m0s")
+		assert.Contains(t, out, " *  1  1")
+		assert.Contains(t, out, ">   2  2")
+		assert.Contains(t, out, "[##########..........]")
+		assert.Contains(t, out, "Stopped build 3")
+	})
+
+	t.Run("colour", func(t *testing.T) {
+		var buf bytes.Buffer
+		renderWatch(&buf, "master", entries, 10*time.Minute, 0, "", true)
+
+		out := buf.String()
+		assert.Contains(t, out, stateColors[stateRunning]+"testing")
+		assert.Contains(t, out, stateColors[stateQueued]+"waiting")
+	})
+
+	t.Run("empty", func(t *testing.T) {
+		var buf bytes.Buffer
+		renderWatch(&buf, "master", nil, 0, 0, "", false)
+		assert.Contains(t, buf.String(), "No builds in the queue")
+	})
+}
+
+// countingBuildGetter counts the ListBuilds calls made to it.
+type countingBuildGetter struct {
+	mockBuildList
+	calls int
+}
+
+func (m *countingBuildGetter) ListBuilds(ctx context.Context, projectUUID string, opts ...codeship.PaginationOption) (codeship.BuildList, codeship.Response, error) {
+	m.calls++
+	return m.mockBuildList.ListBuilds(ctx, projectUUID, opts...)
+}
+
+func TestWatchTerminalWithoutKeys(t *testing.T) {
+	builds := &countingBuildGetter{}
+	monitor := &monitor{buildGetter: builds}
+
+	keys := make(chan byte)
+	close(keys)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	var buf bytes.Buffer
+	err := monitor.watchTerminal(ctx, &buf, keys, "project-uuid", "master", 5*time.Millisecond, 0)
+	_, ok := err.(interruptedError)
+	require.True(t, ok)
+	assert.True(t, builds.calls > 1)
+}